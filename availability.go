@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const DateLayout = "2006-01-02"
+
+// Date is a calendar day which is independent of any time zone.
+type Date struct {
+	time.Time
+}
+
+func (date *Date) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	ts, err := time.Parse(DateLayout, raw)
+	if err != nil {
+		return err
+	}
+
+	date.Time = ts
+	return nil
+}
+
+// Absence is an inclusive range of days.
+type Absence struct {
+	From Date `json:"from"`
+	To   Date `json:"to"`
+}
+
+// Contains indicates whether the day of the given time, in its own location,
+// is within the absence.
+func (absence Absence) Contains(ts time.Time) bool {
+	day := time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC)
+	return !day.Before(absence.From.Time) && !day.After(absence.To.Time)
+}
+
+type Availability struct {
+	Users    map[string][]Absence `json:"users"`
+	ICal     string               `json:"ical"`
+	Reassign bool                 `json:"reassign"`
+}
+
+// LoadICal reads the events of the iCalendar file where times without a time
+// zone are in the given location. No events are returned if no file is
+// configured.
+func (availability *Availability) LoadICal(loc *time.Location) ([]ICalEvent, error) {
+	if availability.ICal == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(availability.ICal)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open '%v': %v", availability.ICal, err)
+	}
+
+	return ParseICal(string(data), loc), nil
+}
+
+// Unavailable returns the set of Github users that are away at the given
+// time where absences are evaluated on the day of the given time in its
+// location. The events of the iCalendar file are associated to a user via the
+// first word of their summary which must be either the Github or Slack username
+// of the user (e.g. `github-user-a: vacation`).
+func (availability *Availability) Unavailable(config *Config, events []ICalEvent, now time.Time) Set {
+	result := NewSet()
+
+	for user, absences := range availability.Users {
+		for _, absence := range absences {
+			if absence.Contains(now) {
+				result.Put(user)
+			}
+		}
+	}
+
+	users := make(map[string]string)
+	for github, slack := range config.Users {
+		users[github] = github
+		users[slack] = github
+	}
+
+	for _, event := range events {
+		if !event.Contains(now) {
+			continue
+		}
+
+		fields := strings.Fields(event.Summary)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if user, ok := users[strings.TrimSuffix(fields[0], ":")]; ok {
+			result.Put(user)
+		}
+	}
+
+	return result
+}
+
+type ICalEvent struct {
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+func (event ICalEvent) Contains(ts time.Time) bool {
+	return !ts.Before(event.Start) && ts.Before(event.End)
+}
+
+// ParseICal extracts the VEVENT entries of an iCalendar file where times
+// without a time zone, including all-day dates, are in the given location. Only
+// the subset needed to figure out absences is supported: SUMMARY, DTSTART and
+// DTEND. Events without a DTEND are considered to last a day and only the first
+// occurrence of recurring events is considered.
+func ParseICal(data string, loc *time.Location) []ICalEvent {
+	var events []ICalEvent
+	var event *ICalEvent
+	recurring := false
+
+	for _, line := range unfoldICal(data) {
+		split := strings.SplitN(line, ":", 2)
+		if len(split) != 2 {
+			continue
+		}
+
+		params := strings.Split(split[0], ";")
+		name, value := strings.ToUpper(params[0]), split[1]
+
+		switch {
+
+		case name == "BEGIN" && value == "VEVENT":
+			event = &ICalEvent{}
+			recurring = false
+
+		case name == "END" && value == "VEVENT" && event != nil:
+			if recurring {
+				Warning("unsupported recurring iCalendar event '%v': only its first occurrence is considered",
+					event.Summary)
+			}
+			if event.End.IsZero() {
+				event.End = event.Start.AddDate(0, 0, 1)
+			}
+			if !event.Start.IsZero() {
+				events = append(events, *event)
+			}
+			event = nil
+
+		case event == nil:
+
+		case name == "SUMMARY":
+			event.Summary = value
+
+		case name == "DTSTART":
+			event.Start = parseICalTime(value, params[1:], loc)
+
+		case name == "DTEND":
+			event.End = parseICalTime(value, params[1:], loc)
+
+		case name == "RRULE" || name == "RDATE" || name == "EXDATE":
+			recurring = true
+		}
+	}
+
+	return events
+}
+
+func unfoldICal(data string) []string {
+	var lines []string
+
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+		} else {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+func parseICalTime(value string, params []string, loc *time.Location) time.Time {
+	for _, param := range params {
+		if strings.HasPrefix(param, "TZID=") {
+			if tz, err := time.LoadLocation(strings.TrimPrefix(param, "TZID=")); err == nil {
+				loc = tz
+			}
+		}
+	}
+
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if strings.HasSuffix(layout, "Z") {
+			if ts, err := time.Parse(layout, value); err == nil {
+				return ts
+			}
+		} else if ts, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return ts
+		}
+	}
+
+	Warning("unable to parse iCalendar time '%v'", value)
+	return time.Time{}
+}
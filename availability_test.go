@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestICal(t *testing.T) {
+	Debug("[ ical ]================================================")
+
+	loc := time.FixedZone("serve", -4*60*60)
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		title string
+		event string
+		exp   []ICalEvent
+	}{
+		{"all-day", `
+SUMMARY:u1: vacation
+DTSTART;VALUE=DATE:20200601
+DTEND;VALUE=DATE:20200603`,
+			[]ICalEvent{{"u1: vacation",
+				time.Date(2020, 6, 1, 0, 0, 0, 0, loc), time.Date(2020, 6, 3, 0, 0, 0, 0, loc)}}},
+
+		{"tzid", `
+SUMMARY:u1
+DTSTART;TZID=Europe/Paris:20200601T090000
+DTEND;TZID=Europe/Paris:20200601T170000`,
+			[]ICalEvent{{"u1",
+				time.Date(2020, 6, 1, 9, 0, 0, 0, paris), time.Date(2020, 6, 1, 17, 0, 0, 0, paris)}}},
+
+		{"utc", `
+SUMMARY:u1
+DTSTART:20200601T090000Z
+DTEND:20200601T170000Z`,
+			[]ICalEvent{{"u1",
+				time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC), time.Date(2020, 6, 1, 17, 0, 0, 0, time.UTC)}}},
+
+		{"floating", `
+SUMMARY:u1
+DTSTART:20200601T090000
+DTEND:20200601T170000`,
+			[]ICalEvent{{"u1",
+				time.Date(2020, 6, 1, 9, 0, 0, 0, loc), time.Date(2020, 6, 1, 17, 0, 0, 0, loc)}}},
+
+		{"folded", "\r\nSUMMARY:u1: a very\r\n  long vacation\r\nDTSTART;VALUE=DATE:\r\n\t20200601",
+			[]ICalEvent{{"u1: a very long vacation",
+				time.Date(2020, 6, 1, 0, 0, 0, 0, loc), time.Date(2020, 6, 2, 0, 0, 0, 0, loc)}}},
+
+		{"missing-dtend", `
+SUMMARY:u1
+DTSTART:20200601T090000Z`,
+			[]ICalEvent{{"u1",
+				time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC), time.Date(2020, 6, 2, 9, 0, 0, 0, time.UTC)}}},
+
+		{"recurring", `
+SUMMARY:u1
+DTSTART;VALUE=DATE:20200601
+RRULE:FREQ=WEEKLY;BYDAY=MO`,
+			[]ICalEvent{{"u1",
+				time.Date(2020, 6, 1, 0, 0, 0, 0, loc), time.Date(2020, 6, 2, 0, 0, 0, 0, loc)}}},
+
+		{"missing-dtstart", `
+SUMMARY:u1
+DTEND;VALUE=DATE:20200601`,
+			nil},
+	} {
+		data := "BEGIN:VCALENDAR\nBEGIN:VEVENT" + test.event + "\nEND:VEVENT\nEND:VCALENDAR\n"
+		events := ParseICal(data, loc)
+
+		if len(events) != len(test.exp) {
+			t.Errorf("%v: val=%v exp=%v", test.title, events, test.exp)
+			continue
+		}
+
+		for index, event := range events {
+			exp := test.exp[index]
+			if event.Summary != exp.Summary || !event.Start.Equal(exp.Start) || !event.End.Equal(exp.End) {
+				t.Errorf("%v: val=%v exp=%v", test.title, event, exp)
+			}
+		}
+	}
+
+	event := ICalEvent{"u1",
+		time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC), time.Date(2020, 6, 1, 17, 0, 0, 0, time.UTC)}
+
+	for _, test := range []struct {
+		ts  time.Time
+		exp bool
+	}{
+		{time.Date(2020, 6, 1, 8, 59, 59, 0, time.UTC), false},
+		{time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC), true},
+		{time.Date(2020, 6, 1, 16, 59, 59, 0, time.UTC), true},
+		{time.Date(2020, 6, 1, 17, 0, 0, 0, time.UTC), false},
+		{time.Date(2020, 6, 1, 12, 59, 59, 0, loc), true},
+		{time.Date(2020, 6, 1, 13, 0, 0, 0, loc), false},
+	} {
+		if val := event.Contains(test.ts); val != test.exp {
+			t.Errorf("event-contains %v: val=%v exp=%v", test.ts, val, test.exp)
+		}
+	}
+}
+
+func TestAbsence(t *testing.T) {
+	Debug("[ absence ]=============================================")
+
+	config := ParseConfig("test", []byte(`
+{
+    "repos": [{ "path": "org/repo", "rule": "r1" }],
+    "github_to_slack_user": { "u1": "s1", "u2": "s2", "u3": "s3" },
+    "pools": { "p1": [ "u1", "u2", "u3" ] },
+    "ruleset": { "r1": [{ "pick": ["p1"] }] },
+    "availability": {
+        "users": { "u1": [{ "from": "2020-06-01", "to": "2020-06-02" }] }
+    }
+}`))
+
+	absence := config.Availability.Users["u1"][0]
+	east := time.FixedZone("east", 10*60*60)
+	west := time.FixedZone("west", -10*60*60)
+
+	for _, test := range []struct {
+		ts  time.Time
+		exp bool
+	}{
+		{time.Date(2020, 5, 31, 23, 59, 59, 0, time.UTC), false},
+		{time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		{time.Date(2020, 6, 2, 23, 59, 59, 0, time.UTC), true},
+		{time.Date(2020, 6, 3, 0, 0, 0, 0, time.UTC), false},
+		{time.Date(2020, 6, 1, 2, 0, 0, 0, east), true},
+		{time.Date(2020, 5, 31, 20, 0, 0, 0, west), false},
+		{time.Date(2020, 5, 31, 20, 0, 0, 0, west).In(east), true},
+	} {
+		if val := absence.Contains(test.ts); val != test.exp {
+			t.Errorf("absence-contains %v: val=%v exp=%v", test.ts, val, test.exp)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "gups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.Availability.ICal = filepath.Join(dir, "vacations.ics")
+	if _, err := config.Availability.LoadICal(time.UTC); err == nil {
+		t.Errorf("expected error for missing iCalendar file")
+	}
+
+	ical := "BEGIN:VEVENT\nSUMMARY:s2: vacation\nDTSTART;VALUE=DATE:20200601\nEND:VEVENT\n" +
+		"BEGIN:VEVENT\nSUMMARY:u3 sick\nDTSTART;VALUE=DATE:20200602\nEND:VEVENT\n"
+	if err := ioutil.WriteFile(config.Availability.ICal, []byte(ical), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := config.Availability.LoadICal(time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	CheckSet(t, "unavailable", NewSet("u1", "u2"), config.Availability.Unavailable(config, events, now))
+}
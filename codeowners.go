@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+)
+
+var CodeOwnersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+type CodeOwnersRule struct {
+	Pattern string
+	Owners  []string
+}
+
+type CodeOwners []CodeOwnersRule
+
+func ParseCodeOwners(text string) CodeOwners {
+	var owners CodeOwners
+
+	for _, line := range strings.Split(text, "\n") {
+		if index := strings.Index(line, "#"); index >= 0 {
+			line = line[:index]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if !ValidGlob(fields[0]) {
+			Warning("skipping malformed CODEOWNERS pattern '%v'", fields[0])
+			continue
+		}
+
+		owners = append(owners, CodeOwnersRule{
+			Pattern: fields[0],
+			Owners:  fields[1:],
+		})
+	}
+
+	return owners
+}
+
+// Match follows the gitignore-like semantics of CODEOWNERS: patterns without an
+// inner slash match at any depth and a pattern naming a directory matches
+// everything under it. Wildcards in the last segment don't match nested files
+// (i.e. `docs/*` doesn't match `docs/a/b.md`).
+func (rule *CodeOwnersRule) Match(file string) bool {
+	pattern := rule.Pattern
+	if !strings.Contains(strings.TrimSuffix(pattern, "/"), "/") {
+		pattern = "**/" + pattern
+	}
+
+	if MatchGlob(pattern, file) {
+		return true
+	}
+
+	last := pattern[strings.LastIndex(pattern, "/")+1:]
+	return last != "" && !strings.ContainsAny(last, "*?[") && MatchGlob(pattern+"/**", file)
+}
+
+// Owners returns the owners of the last matching rule for each of the given
+// files.
+func (owners CodeOwners) Owners(files []string) Set {
+	result := NewSet()
+
+	for _, file := range files {
+		for i := len(owners) - 1; i >= 0; i-- {
+			if owners[i].Match(file) {
+				result.Add(NewSet(owners[i].Owners...))
+				break
+			}
+		}
+	}
+
+	return result
+}
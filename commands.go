@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const commandUsage = "Usage:\n" +
+	"- `/gups mine`: sends your full summary\n" +
+	"- `/gups repo <org>/<repo>`: lists the open PRs of a repo\n" +
+	"- `/gups who <org>/<repo>#<number>`: explains how the reviewers of a PR were picked"
+
+// SlackCommandHandler returns the handler for the `/gups` slash command.
+// Commands are executed in the background and their output is sent through the
+// response URL of the command.
+func SlackCommandHandler(engine *Engine, secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := verifySlack(w, r, secret)
+		if !ok {
+			return
+		}
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, "malformed payload", http.StatusBadRequest)
+			return
+		}
+
+		reply := func(text string) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&slack.Msg{ResponseType: slack.ResponseTypeEphemeral, Text: text})
+		}
+
+		user, ok := engine.GithubUser(form.Get("user_id"))
+		if !ok {
+			reply("You're not configured in `github_to_slack_user`")
+			return
+		}
+
+		args := strings.Fields(form.Get("text"))
+		if len(args) == 0 {
+			reply(commandUsage)
+			return
+		}
+
+		Info("slack command '%v' from %v", strings.Join(args, " "), user)
+
+		var command func() string
+		switch {
+
+		case args[0] == "mine" && len(args) == 1:
+			command = func() string { return engine.Mine(user) }
+
+		case args[0] == "repo" && len(args) == 2:
+			path := args[1]
+			command = func() string { return engine.RepoStatus(path) }
+
+		case args[0] == "who" && len(args) == 2:
+			index := strings.LastIndex(args[1], "#")
+			if index < 0 {
+				reply(commandUsage)
+				return
+			}
+
+			number, err := strconv.Atoi(args[1][index+1:])
+			if err != nil {
+				reply(commandUsage)
+				return
+			}
+
+			path := args[1][:index]
+			command = func() string { return engine.Explain(path, number) }
+
+		default:
+			reply(commandUsage)
+			return
+		}
+
+		responseURL := form.Get("response_url")
+		engine.Async(func() {
+			msg := &slack.WebhookMessage{Text: command()}
+			if err := slack.PostWebhook(responseURL, msg); err != nil {
+				Warning("unable to respond to slack command: %v", err)
+			}
+		})
+
+		reply("Working on it...")
+	})
+}
+
+func prLink(path string, number int32) string {
+	return fmt.Sprintf("<https://github.com/%v/pull/%v|%v#%v>", path, number, path, number)
+}
+
+func userList(users Set) string {
+	if users.Empty() {
+		return "none"
+	}
+	return strings.Join(users.ToArray(), ", ")
+}
+
+// Mine sends the full summary of the given user without modifying any of the
+// review requests. Only the PRs of the configured repos that the user authored,
+// was requested to review or reviewed are queried and, as with regular runs,
+// snoozed and reviewed PRs are left out.
+func (engine *Engine) Mine(user string) string {
+	ruleset, away := engine.snapshot()
+	if away.Test(user) {
+		return "You're currently away so no summary was sent"
+	}
+
+	refs, err := engine.github.SearchPullRequests(context.TODO(), user)
+	if err != nil {
+		Warning("unable to search the PRs of %v: %v", user, err)
+		return "Unable to query your PRs"
+	}
+
+	notifs := make(UserNotifications)
+	for _, ref := range refs {
+		repo, ok := engine.Repo(ref.Path)
+		if !ok {
+			continue
+		}
+
+		pr, err := engine.queryPullRequest(ruleset, repo, ref.Number)
+		if err != nil {
+			Warning("<%v> skipping PR of %v: %v", ref.Number, repo.Path, err)
+			continue
+		}
+
+		if pr == nil {
+			continue
+		}
+
+		if result := ruleset.Apply(repo.Rule, pr); !result.Skip {
+			engine.digest(ruleset, repo, pr, result, notifs)
+		}
+	}
+
+	engine.lock.Lock()
+	notif := engine.filter(user, notifs[user], true, nil)
+	slackUser := engine.slackUsers[user]
+	engine.lock.Unlock()
+
+	if len(notif) == 0 {
+		return "Nothing to report"
+	}
+
+	if err := NotifySlack(engine.slack, slackUser, notif, engine.interactive, engine.dryRun); err != nil {
+		Warning("unable to notify slack: %v", err)
+		return "Unable to send your summary"
+	}
+
+	return "Your summary was sent"
+}
+
+// RepoStatus describes the assignment state of the open PRs of the given repo.
+func (engine *Engine) RepoStatus(path string) string {
+	repo, ok := engine.Repo(path)
+	if !ok {
+		return fmt.Sprintf("Unknown repo `%v`", path)
+	}
+
+	ruleset, _ := engine.snapshot()
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString(fmt.Sprintf("*%v*:\n", repo.Path))
+
+	pullRequests, err := engine.queryRepo(ruleset, repo)
+	if err != nil {
+		Warning("unable to query %v: %v", repo.Path, err)
+		return fmt.Sprintf("Unable to query `%v`", repo.Path)
+	}
+
+	if len(pullRequests) == 0 {
+		buffer.WriteString("No open PRs")
+	}
+
+	for _, pr := range pullRequests {
+		result := ruleset.Apply(repo.Rule, pr)
+		pending := result.Pending.Difference(result.New)
+
+		state := ""
+		switch {
+		case result.Skip || result.Rule < 0:
+			state = "unassigned"
+		case result.Ready && len(pr.Blockers()) > 0:
+			state = fmt.Sprintf("approved but blocked (%v)", strings.Join(pr.Blockers(), ", "))
+		case result.Ready:
+			state = "ready to merge"
+		case !result.AwaitingAuthor.Empty():
+			state = fmt.Sprintf("awaiting author, changes requested by %v", userList(result.AwaitingAuthor))
+		case pending.Empty():
+			state = "awaiting reviewer assignment"
+		default:
+			state = fmt.Sprintf("pending %v", userList(pending))
+		}
+
+		buffer.WriteString(fmt.Sprintf("- [%v] *%v*: %v _(%v, %v)_\n",
+			pr.Age, prLink(repo.Path, pr.Number), pr.Title, pr.Author, state))
+	}
+
+	return buffer.String()
+}
+
+// Explain describes which rule of the repo's ruleset applies to the PR and how
+// its reviewers are picked.
+func (engine *Engine) Explain(path string, number int) string {
+	repo, ok := engine.Repo(path)
+	if !ok {
+		return fmt.Sprintf("Unknown repo `%v`", path)
+	}
+
+	ruleset, _ := engine.snapshot()
+
+	pr, err := engine.queryPullRequest(ruleset, repo, number)
+	if err != nil {
+		Warning("unable to query %v#%v: %v", repo.Path, number, err)
+		return fmt.Sprintf("Unable to query %v#%v", repo.Path, number)
+	}
+	if pr == nil {
+		return fmt.Sprintf("No open PR %v#%v", repo.Path, number)
+	}
+
+	result := ruleset.Apply(repo.Rule, pr)
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString(fmt.Sprintf("*%v*: %v\n", prLink(repo.Path, pr.Number), pr.Title))
+
+	if result.Skip || result.Rule < 0 {
+		buffer.WriteString(fmt.Sprintf(
+			"No rule of ruleset `%v` applies to this PR (skipped label, draft or no matching rule)\n",
+			repo.Rule))
+		return buffer.String()
+	}
+
+	rule := ruleset.Rule(repo.Rule, result.Rule)
+
+	var picks []string
+	for _, pick := range rule.Pick {
+		picks = append(picks, pick.String())
+	}
+
+	buffer.WriteString(fmt.Sprintf("- rule %v of ruleset `%v` matched: `%v`\n",
+		result.Rule, repo.Rule, rule.Conditions()))
+	buffer.WriteString(fmt.Sprintf("- picks: `%v`\n", strings.Join(picks, "`, `")))
+	if pr.RequiredApprovals > 0 || pr.RequiresCodeOwners {
+		buffer.WriteString(fmt.Sprintf("- branch protection: %v approvals, code owners required: %v\n",
+			pr.RequiredApprovals, pr.RequiresCodeOwners))
+	}
+	buffer.WriteString(fmt.Sprintf("- assigned: %v\n", userList(result.Assigned.Difference(result.New))))
+	buffer.WriteString(fmt.Sprintf("- reviewed: %v\n", userList(pr.Reviewed())))
+	buffer.WriteString(fmt.Sprintf("- pending: %v\n", userList(result.Pending.Difference(result.New))))
+	buffer.WriteString(fmt.Sprintf("- manually requested: %v\n", userList(result.Requested)))
+
+	if unavailable := ruleset.Unavailable(); !unavailable.Empty() {
+		buffer.WriteString(fmt.Sprintf("- unavailable: %v\n", userList(unavailable)))
+	}
+
+	if !result.New.Empty() {
+		buffer.WriteString(fmt.Sprintf("- to be picked on the next run: %v\n", userList(result.New)))
+	}
+
+	for _, assignment := range engine.store.Assignments(time.Time{}) {
+		if assignment.Path != repo.Path || assignment.Number != number {
+			continue
+		}
+
+		buffer.WriteString(fmt.Sprintf("- %v was picked by rule %v of ruleset `%v` on %v\n",
+			assignment.User, assignment.Rule, assignment.Ruleset, assignment.Time.Format(DateLayout)))
+	}
+
+	for _, escalation := range engine.store.Escalations(time.Time{}) {
+		if escalation.Path != repo.Path || escalation.Number != number {
+			continue
+		}
+
+		buffer.WriteString(fmt.Sprintf("- review of %v was escalated to %v on %v\n",
+			escalation.User, strings.Join(escalation.Picked, ", "), escalation.Time.Format(DateLayout)))
+	}
+
+	return buffer.String()
+}
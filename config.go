@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"path"
+	"regexp"
 	"strings"
+	"time"
 )
 
 type Pool []string
@@ -22,12 +27,139 @@ type Repo struct {
 	Rule string `json:"rule"`
 }
 
+type DraftMode string
+
+const (
+	DraftAssign DraftMode = "assign"
+	DraftReady  DraftMode = "ready"
+	DraftAuthor DraftMode = "author"
+	DraftSkip   DraftMode = "skip"
+)
+
+// RepoSelector selects the repositories of a Github organization where path is of
+// the form `<org>/<pattern>` and the pattern follows the semantics of path.Match.
+// Like Github, repos are matched regardless of case.
+type RepoSelector struct {
+	Path    string   `json:"path"`
+	Regex   string   `json:"regex"`
+	Topics  []string `json:"topics"`
+	Exclude []string `json:"exclude"`
+	Rule    string   `json:"rule"`
+
+	regex   *regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func (selector *RepoSelector) Org() string {
+	return PathToVariables(selector.Path).Owner
+}
+
+func (selector *RepoSelector) Match(repo Repository) bool {
+	if repo.Archived {
+		return false
+	}
+
+	pattern := strings.ToLower(PathToVariables(selector.Path).Repository)
+	name := strings.ToLower(PathToVariables(repo.Path).Repository)
+	if ok, _ := path.Match(pattern, name); !ok {
+		return false
+	}
+
+	if selector.regex != nil && !selector.regex.MatchString(repo.Path) {
+		return false
+	}
+
+	if len(selector.Topics) > 0 && repo.Topics.Fold().Intersect(NewSet(selector.Topics...).Fold()).Empty() {
+		return false
+	}
+
+	for _, exclude := range selector.exclude {
+		if exclude.MatchString(repo.Path) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type ReviewCaps struct {
+	Default int            `json:"default"`
+	Pools   map[string]int `json:"pools"`
+	Users   map[string]int `json:"users"`
+}
+
+type SlackStatus struct {
+	Patterns []string `json:"patterns"`
+	DND      bool     `json:"dnd"`
+
+	patterns []*regexp.Regexp
+}
+
+func (status *SlackStatus) Enabled() bool {
+	return len(status.Patterns) > 0 || status.DND
+}
+
+func (status *SlackStatus) Away(text string) bool {
+	for _, pattern := range status.patterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+const DefaultFairWindowDays = 30
+
+type Job struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Full     bool   `json:"full"`
+
+	schedule *Schedule
+}
+
+type ServeConfig struct {
+	Listen   string `json:"listen"`
+	Timezone string `json:"timezone"`
+	Jobs     []Job  `json:"jobs"`
+
+	location *time.Location
+}
+
 type Config struct {
-	Users      map[string]string `json:"github_to_slack_user"`
-	Pools      map[string]Pool   `json:"pools"`
-	Ruleset    map[string]Rules  `json:"ruleset"`
-	Repos      []Repo            `json:"repos"`
-	SkipLabels []string          `json:"skip_pr_labels"`
+	Users      map[string]string        `json:"github_to_slack_user"`
+	Pools      map[string]Pool          `json:"pools"`
+	Ruleset    map[string]RulesetConfig `json:"ruleset"`
+	Repos      []Repo                   `json:"repos"`
+	Selectors  []RepoSelector           `json:"repo_selectors"`
+	SkipLabels []string                 `json:"skip_pr_labels"`
+	DraftPRs   DraftMode                `json:"draft_prs"`
+	ReviewCaps ReviewCaps               `json:"max_pending_reviews"`
+
+	Availability Availability `json:"availability"`
+	SlackStatus  SlackStatus  `json:"slack_status"`
+	Serve        ServeConfig  `json:"serve"`
+	State        StateConfig  `json:"state"`
+
+	FairWindowDays int `json:"fair_window_days"`
+
+	// teamPools holds the pools as configured before their teams are expanded.
+	teamPools map[string]Pool
+}
+
+// UsesFair returns true if any of the rules picks reviewers using the fair
+// strategy.
+func (config *Config) UsesFair() bool {
+	for _, ruleset := range config.Ruleset {
+		for _, rule := range ruleset.Rules {
+			for _, pick := range rule.Pick {
+				if pick.Strategy == PickFair {
+					return true
+				}
+			}
+		}
+	}
+	return false
 }
 
 func ReadConfig(file string) *Config {
@@ -56,14 +188,153 @@ func ParseConfig(name string, data []byte) *Config {
 
 	for poolName, pool := range config.Pools {
 		for _, user := range pool {
+			if IsTeam(user) {
+				continue
+			}
+
 			if _, ok := config.Users[user]; !ok {
 				Fatal("unknown user '%v' in pool '%v'", user, poolName)
 			}
 		}
 	}
 
-	if len(config.Repos) == 0 {
-		Fatal("missing field 'repos' in '%v'", name)
+	if len(config.Repos) == 0 && len(config.Selectors) == 0 {
+		Fatal("missing field 'repos' or 'repo_selectors' in '%v'", name)
+	}
+
+	for index := range config.Selectors {
+		selector := &config.Selectors[index]
+
+		vars := PathToVariables(selector.Path)
+		if _, err := path.Match(vars.Repository, ""); err != nil {
+			Fatal("malformed repo selector path '%v': %v", selector.Path, err)
+		}
+
+		if selector.Regex != "" {
+			re, err := regexp.Compile("(?i)" + selector.Regex)
+			if err != nil {
+				Fatal("malformed repo selector regex '%v': %v", selector.Regex, err)
+			}
+			selector.regex = re
+		}
+
+		for _, exclude := range selector.Exclude {
+			re, err := regexp.Compile("(?i)" + exclude)
+			if err != nil {
+				Fatal("malformed repo selector exclude '%v': %v", exclude, err)
+			}
+			selector.exclude = append(selector.exclude, re)
+		}
+
+		if _, ok := config.Ruleset[selector.Rule]; !ok {
+			Fatal("unknown rule '%v' in repo selector '%v'", selector.Rule, selector.Path)
+		}
+	}
+
+	switch config.DraftPRs {
+	case "":
+		config.DraftPRs = DraftAssign
+	case DraftAssign, DraftReady, DraftAuthor, DraftSkip:
+	default:
+		Fatal("unknown draft_prs mode '%v' in '%v'", config.DraftPRs, name)
+	}
+
+	if config.ReviewCaps.Default < 0 {
+		Fatal("invalid default max_pending_reviews '%v' in '%v'", config.ReviewCaps.Default, name)
+	}
+
+	for poolName, cap := range config.ReviewCaps.Pools {
+		if _, ok := config.Pools[poolName]; !ok && poolName != CodeOwnersPool {
+			Fatal("unknown pool '%v' in max_pending_reviews", poolName)
+		}
+		if cap < 0 {
+			Fatal("invalid max_pending_reviews '%v' for pool '%v'", cap, poolName)
+		}
+	}
+
+	for user, cap := range config.ReviewCaps.Users {
+		if _, ok := config.Users[user]; !ok {
+			Fatal("unknown user '%v' in max_pending_reviews", user)
+		}
+		if cap < 0 {
+			Fatal("invalid max_pending_reviews '%v' for user '%v'", cap, user)
+		}
+	}
+
+	for user, _ := range config.Availability.Users {
+		if _, ok := config.Users[user]; !ok {
+			Fatal("unknown user '%v' in availability", user)
+		}
+	}
+
+	for _, pattern := range config.SlackStatus.Patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			Fatal("malformed slack_status pattern '%v': %v", pattern, err)
+		}
+		config.SlackStatus.patterns = append(config.SlackStatus.patterns, re)
+	}
+
+	switch config.State.Store {
+	case "":
+		switch {
+		case config.State.Path == "":
+			config.State.Store = StoreMemory
+		case strings.EqualFold(path.Ext(config.State.Path), ".json"):
+			config.State.Store = StoreJSON
+		default:
+			config.State.Store = StoreBolt
+		}
+	case StoreMemory:
+	case StoreJSON, StoreBolt:
+		if config.State.Path == "" {
+			Fatal("missing state path for the %v store in '%v'", config.State.Store, name)
+		}
+	default:
+		Fatal("unknown state store '%v' in '%v'", config.State.Store, name)
+	}
+
+	if config.State.RetentionDays == 0 {
+		config.State.RetentionDays = DefaultRetentionDays
+	} else if config.State.RetentionDays < 0 {
+		Fatal("invalid state retention_days '%v' in '%v'", config.State.RetentionDays, name)
+	}
+
+	if config.FairWindowDays == 0 {
+		config.FairWindowDays = DefaultFairWindowDays
+	} else if config.FairWindowDays < 0 {
+		Fatal("invalid fair_window_days '%v' in '%v'", config.FairWindowDays, name)
+	}
+
+	if config.UsesFair() {
+		if config.FairWindowDays > config.State.RetentionDays {
+			Fatal("fair_window_days '%v' exceeds the state retention_days '%v' in '%v'",
+				config.FairWindowDays, config.State.RetentionDays, name)
+		}
+
+		if config.State.Store == StoreMemory {
+			Warning("the fair strategy only accounts for the reviews assigned since Gups started " +
+				"with the memory state store and behaves like random in one-shot mode")
+		}
+	}
+
+	config.Serve.location = time.Local
+	if config.Serve.Timezone != "" {
+		loc, err := time.LoadLocation(config.Serve.Timezone)
+		if err != nil {
+			Fatal("unknown serve timezone '%v': %v", config.Serve.Timezone, err)
+		}
+		config.Serve.location = loc
+	}
+
+	for index := range config.Serve.Jobs {
+		job := &config.Serve.Jobs[index]
+
+		schedule, err := ParseSchedule(job.Schedule)
+		if err != nil {
+			Fatal("malformed schedule '%v' for job '%v': %v", job.Schedule, job.Name, err)
+		}
+		job.schedule = schedule
 	}
 
 	for _, repo := range config.Repos {
@@ -76,6 +347,87 @@ func ParseConfig(name string, data []byte) *Config {
 	return config
 }
 
+// ExpandRepos adds the repositories matched by the repo selectors to the list of
+// repos. See SelectRepos.
+func (config *Config) ExpandRepos(ctx context.Context, client *GithubClient) {
+	orgs := make(map[string][]Repository)
+
+	for _, selector := range config.Selectors {
+		org := strings.ToLower(selector.Org())
+		if _, ok := orgs[org]; !ok {
+			orgs[org] = client.QueryRepositories(ctx, selector.Org())
+		}
+	}
+
+	config.SelectRepos(orgs)
+}
+
+// SelectRepos adds the repositories matched by the repo selectors to the list of
+// repos where the repositories are indexed by their lower case organization.
+// Explicit repo entries take precedence over selectors and the first matching
+// selector wins.
+func (config *Config) SelectRepos(orgs map[string][]Repository) {
+	known := NewSet()
+	for _, repo := range config.Repos {
+		known.Put(strings.ToLower(repo.Path))
+	}
+
+	for _, selector := range config.Selectors {
+		for _, repo := range orgs[strings.ToLower(selector.Org())] {
+			if known.Test(strings.ToLower(repo.Path)) || !selector.Match(repo) {
+				continue
+			}
+
+			Info("selected repo '%v' with rule '%v'", repo.Path, selector.Rule)
+			config.Repos = append(config.Repos, Repo{Path: repo.Path, Rule: selector.Rule})
+			known.Put(strings.ToLower(repo.Path))
+		}
+	}
+}
+
+func IsTeam(user string) bool {
+	return strings.HasPrefix(user, "@")
+}
+
+// ExpandTeams replaces the '@org/team' entries of every pool with the members of
+// that Github team. Members that are not configured users are ignored. The teams
+// are expanded from the original pools such that it can be called again to pick
+// up membership changes. On error, the pools are left unchanged.
+func (config *Config) ExpandTeams(ctx context.Context, client *GithubClient) error {
+	if config.teamPools == nil {
+		config.teamPools = config.Pools
+	}
+
+	pools := make(map[string]Pool)
+	for poolName, pool := range config.teamPools {
+		var expanded Pool
+
+		for _, user := range pool {
+			if !IsTeam(user) {
+				expanded = append(expanded, user)
+				continue
+			}
+
+			team := strings.TrimPrefix(user, "@")
+			members, err := client.TeamMembers(ctx, team)
+			if err != nil {
+				return fmt.Errorf("unable to get members of team '%v' in pool '%v': %v", team, poolName, err)
+			}
+
+			for member, _ := range members {
+				if _, ok := config.Users[member]; ok && !expanded.Contains(member) {
+					expanded = append(expanded, member)
+				}
+			}
+		}
+
+		pools[poolName] = expanded
+	}
+
+	config.Pools = pools
+	return nil
+}
+
 func PathToVariables(path string) Variables {
 	split := strings.Split(path, "/")
 
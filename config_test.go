@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRepoSelectors(t *testing.T) {
+	Debug("[ repo selectors ]======================================")
+
+	config := ParseConfig("test", []byte(`
+{
+    "repos": [{ "path": "Org/Explicit", "rule": "r1" }],
+    "repo_selectors": [
+        { "path": "org/svc-*", "exclude": [ "-legacy$" ], "rule": "r2" },
+        { "path": "org/*", "regex": "^org/lib-[0-9]+$", "rule": "r3" },
+        { "path": "org/*", "topics": [ "Backend" ], "rule": "r4" },
+        { "path": "other/*", "rule": "r5" },
+        { "path": "org/*", "rule": "r6" }
+    ],
+    "github_to_slack_user": { "u1": "s1" },
+    "pools": { "p1": [ "u1" ] },
+    "ruleset": {
+        "r1": [{ "pick": ["p1"] }],
+        "r2": [{ "pick": ["p1"] }],
+        "r3": [{ "pick": ["p1"] }],
+        "r4": [{ "pick": ["p1"] }],
+        "r5": [{ "pick": ["p1"] }],
+        "r6": [{ "pick": ["p1"] }]
+    }
+}`))
+
+	for _, test := range []struct {
+		selector int
+		repo     Repository
+		exp      bool
+	}{
+		{0, Repository{Path: "org/svc-api"}, true},
+		{0, Repository{Path: "org/SVC-Api"}, true},
+		{0, Repository{Path: "org/svc-api-legacy"}, false},
+		{0, Repository{Path: "org/svc-api-LEGACY"}, false},
+		{0, Repository{Path: "org/svc-api", Archived: true}, false},
+		{0, Repository{Path: "org/web"}, false},
+		{1, Repository{Path: "org/lib-12"}, true},
+		{1, Repository{Path: "ORG/Lib-12"}, true},
+		{1, Repository{Path: "org/lib-x"}, false},
+		{2, Repository{Path: "org/web", Topics: NewSet("backend", "go")}, true},
+		{2, Repository{Path: "org/web", Topics: NewSet("BACKEND")}, true},
+		{2, Repository{Path: "org/web", Topics: NewSet("frontend")}, false},
+		{2, Repository{Path: "org/web"}, false},
+	} {
+		selector := config.Selectors[test.selector]
+		if val := selector.Match(test.repo); val != test.exp {
+			t.Errorf("match %v '%v': val=%v exp=%v", selector.Path, test.repo.Path, val, test.exp)
+		}
+	}
+
+	config.SelectRepos(map[string][]Repository{
+		"org": {
+			{Path: "org/explicit"},
+			{Path: "org/svc-api"},
+			{Path: "org/svc-api-legacy"},
+			{Path: "org/lib-1", Topics: NewSet("backend")},
+			{Path: "org/web", Topics: NewSet("backend")},
+			{Path: "org/docs"},
+			{Path: "org/old", Archived: true},
+		},
+		"other": {
+			{Path: "Other/Tool"},
+		},
+	})
+
+	exp := map[string]string{
+		"Org/Explicit":       "r1",
+		"org/svc-api":        "r2",
+		"org/svc-api-legacy": "r6",
+		"org/lib-1":          "r3",
+		"org/web":            "r4",
+		"org/docs":           "r6",
+		"Other/Tool":         "r5",
+	}
+
+	if len(config.Repos) != len(exp) {
+		t.Errorf("repos: val=%v exp=%v", len(config.Repos), len(exp))
+	}
+
+	for _, repo := range config.Repos {
+		if rule, ok := exp[repo.Path]; !ok || rule != repo.Rule {
+			t.Errorf("repo '%v': val=%v exp=%v", repo.Path, repo.Rule, rule)
+		}
+	}
+}
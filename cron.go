@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression of the form:
+//
+//	<minute> <hour> <day-of-month> <month> <day-of-week>
+//
+// where each field is a comma separated list of `*`, values or ranges
+// (e.g. `1-5`), each optionally followed by a step (e.g. `*/10`). Day-of-week
+// ranges from 0 to 7 where both 0 and 7 are Sunday. As with cron, if both the
+// day-of-month and day-of-week fields are restricted then a day matches if
+// either field matches.
+type Schedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	domStar bool
+	dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day-of-month", 1, 31},
+	{"month", 1, 12},
+	{"day-of-week", 0, 7},
+}
+
+func ParseSchedule(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("expected %v fields but got %v", len(cronFields), len(fields))
+	}
+
+	var bits [5]uint64
+	for index, field := range cronFields {
+		value, err := parseCronField(fields[index], field)
+		if err != nil {
+			return nil, err
+		}
+		bits[index] = value
+	}
+
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1 << 0
+	}
+
+	return &Schedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(spec string, field cronField) (uint64, error) {
+	var bits uint64
+
+	for _, item := range strings.Split(spec, ",") {
+		step := 1
+		if split := strings.SplitN(item, "/", 2); len(split) == 2 {
+			value, err := strconv.Atoi(split[1])
+			if err != nil || value <= 0 {
+				return 0, fmt.Errorf("invalid step '%v' in %v field", split[1], field.name)
+			}
+			item, step = split[0], value
+		}
+
+		min, max := field.min, field.max
+		if item != "*" {
+			split := strings.SplitN(item, "-", 2)
+
+			value, err := strconv.Atoi(split[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value '%v' in %v field", split[0], field.name)
+			}
+			min, max = value, value
+
+			if len(split) == 2 {
+				if max, err = strconv.Atoi(split[1]); err != nil {
+					return 0, fmt.Errorf("invalid value '%v' in %v field", split[1], field.name)
+				}
+			} else if step > 1 {
+				max = field.max
+			}
+		}
+
+		if min < field.min || max > field.max || min > max {
+			return 0, fmt.Errorf("invalid range '%v' in %v field", item, field.name)
+		}
+
+		for value := min; value <= max; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func (schedule *Schedule) matchDay(ts time.Time) bool {
+	dom := schedule.dom&(1<<uint(ts.Day())) != 0
+	dow := schedule.dow&(1<<uint(ts.Weekday())) != 0
+
+	if schedule.domStar || schedule.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time strictly after the given time that matches the
+// schedule in the location of the given time. The zero time is returned if no
+// such time exists within the next five years.
+func (schedule *Schedule) Next(ts time.Time) time.Time {
+	loc := ts.Location()
+	ts = ts.Truncate(time.Minute).Add(time.Minute)
+	limit := ts.AddDate(5, 0, 0)
+
+	for ts.Before(limit) {
+		switch {
+
+		case schedule.month&(1<<uint(ts.Month())) == 0:
+			ts = time.Date(ts.Year(), ts.Month()+1, 1, 0, 0, 0, 0, loc)
+
+		case !schedule.matchDay(ts):
+			ts = time.Date(ts.Year(), ts.Month(), ts.Day()+1, 0, 0, 0, 0, loc)
+
+		case schedule.hour&(1<<uint(ts.Hour())) == 0:
+			ts = time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour()+1, 0, 0, 0, loc)
+
+		case schedule.minute&(1<<uint(ts.Minute())) == 0:
+			ts = ts.Add(time.Minute)
+
+		default:
+			return ts
+		}
+	}
+
+	return time.Time{}
+}
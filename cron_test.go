@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	Debug("[ schedule ]============================================")
+
+	check := func(spec, from, exp string) {
+		t.Helper()
+
+		schedule, err := ParseSchedule(spec)
+		if err != nil {
+			t.Errorf("unable to parse '%v': %v", spec, err)
+			return
+		}
+
+		ts, _ := time.Parse("2006-01-02 15:04", from)
+		if next := schedule.Next(ts).Format("2006-01-02 15:04"); next != exp {
+			t.Errorf("'%v' from %v: %v != %v", spec, from, next, exp)
+		}
+	}
+
+	check("* * * * *", "2020-06-01 10:00", "2020-06-01 10:01")
+	check("*/10 * * * *", "2020-06-01 10:05", "2020-06-01 10:10")
+	check("*/10 * * * *", "2020-06-01 10:50", "2020-06-01 11:00")
+	check("0 14 * * 1-5", "2020-06-01 14:00", "2020-06-02 14:00")
+	check("0 14 * * 1-5", "2020-06-05 15:00", "2020-06-08 14:00")
+	check("0 14 * * 0", "2020-06-01 10:00", "2020-06-07 14:00")
+	check("0 14 * * 7", "2020-06-01 10:00", "2020-06-07 14:00")
+	check("30 9 1,15 * *", "2020-06-02 00:00", "2020-06-15 09:30")
+	check("0 0 1 1 *", "2020-06-01 00:00", "2021-01-01 00:00")
+	check("0 0 31 2 *", "2020-06-01 00:00", "0001-01-01 00:00")
+	check("0 0 13 * 5", "2020-06-01 00:00", "2020-06-05 00:00")
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("expected error for '%v'", spec)
+		}
+	}
+}
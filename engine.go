@@ -0,0 +1,616 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// Engine holds the clients and the configuration required to execute the rule
+// engine. Runs are serialized such that the engine can be safely used by the
+// scheduler of the daemon mode.
+type Engine struct {
+	config     *Config
+	github     *GithubClient
+	slack      *slack.Client
+	slackList  []slack.User
+	slackUsers SlackUsers
+	dryRun     bool
+
+	// interactive indicates that Slack actions are handled by the daemon and
+	// can therefore be attached to the notifications.
+	interactive bool
+
+	store Store
+	lock  sync.Mutex
+
+	// slackLock protects the Slack users which are also read by the Slack
+	// handlers without waiting on the jobs holding the lock. They are only
+	// updated while holding both locks.
+	slackLock sync.RWMutex
+
+	tasks sync.WaitGroup
+	load  map[string]int
+
+	// busy caches the users that are away according to Slack until the next
+	// job such that webhooks don't query Slack for every event.
+	busy Set
+
+	// calendar is the last successfully read iCalendar file of the
+	// availability which is kept if the file becomes unreadable.
+	calendar []ICalEvent
+
+	// since is the start of the previous run which is used to find the entries
+	// that changed when no notification was recorded for them.
+	since time.Time
+}
+
+// EntryKey identifies the entries of a PR in the notifications of a user.
+type EntryKey struct {
+	User   string `json:"user"`
+	Path   string `json:"path"`
+	Number int    `json:"number"`
+}
+
+func NewEngine(config *Config, githubClient *GithubClient, slackClient *slack.Client, dryRun bool) *Engine {
+	config.ExpandRepos(context.TODO(), githubClient)
+	if err := config.ExpandTeams(context.TODO(), githubClient); err != nil {
+		Fatal("unable to expand the teams of the pools: %v", err)
+	}
+
+	slackList, err := slackClient.GetUsers()
+	if err != nil {
+		Fatal("unable to get slack users: %v", err)
+	}
+
+	return &Engine{
+		config:     config,
+		github:     githubClient,
+		slack:      slackClient,
+		slackList:  slackList,
+		slackUsers: SlackMapUsers(config, slackList),
+		dryRun:     dryRun,
+		store:      OpenStore(&config.State, dryRun),
+	}
+}
+
+// SetSince sets the start of the previous run which is only used if the state
+// store isn't persistent.
+func (engine *Engine) SetSince(since time.Time) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	engine.since = since
+}
+
+// Run scans all the configured repos, assigns reviewers and sends the Slack
+// notifications. If full is set then the full summary is sent, otherwise only
+// the new or changed entries are sent.
+func (engine *Engine) Run(full bool) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	start := time.Now()
+	engine.refresh()
+
+	config := engine.config
+	ruleset := engine.newRuleset()
+	notifs := make(UserNotifications)
+
+	away := engine.availability(ruleset)
+	pullRequests := engine.query(ruleset)
+
+	for index, repo := range config.Repos {
+		Info("[%v/%v] processing %v...", index+1, len(config.Repos), repo.Path)
+
+		for _, pr := range pullRequests[index] {
+			if _, err := engine.process(ruleset, repo, pr, notifs, true); err != nil {
+				Warning("<%v> skipping PR of %v: %v", pr.Number, repo.Path, err)
+			}
+		}
+	}
+
+	engine.load = ruleset.Load()
+
+	engine.notify(notifs, away, full)
+	engine.flush()
+	stats(notifs, engine.store)
+
+	engine.since = start
+}
+
+// RunPullRequest applies the rules to a single PR of the given repo and only
+// sends the entries of the one-shot categories (e.g. Assigned) since the
+// summary entries are left to the jobs. The review load from the
+// previous run is reused to avoid scanning all the configured repos. The review
+// requests of the declined users are removed and replacements are picked. The
+// result of the rules is returned or nil if the PR is not open.
+func (engine *Engine) RunPullRequest(repo Repo, number int, declined Set) (*Result, error) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	engine.seedLoad()
+	ruleset := engine.newRuleset()
+
+	pr, err := engine.queryPullRequest(ruleset, repo, number)
+	if err != nil {
+		Warning("<%v> unable to query PR of %v: %v", number, repo.Path, err)
+		return nil, err
+	}
+	if pr == nil {
+		Info("<%v> skipping closed PR in %v", number, repo.Path)
+		return nil, nil
+	}
+
+	ruleset.SetLoad(engine.load)
+	ruleset.Decline(declined)
+	notifs := make(UserNotifications)
+
+	away := engine.availability(ruleset)
+
+	Info("processing %v#%v...", repo.Path, number)
+	result, err := engine.process(ruleset, repo, pr, notifs, false)
+	if err != nil {
+		Warning("<%v> skipping PR of %v: %v", number, repo.Path, err)
+		return nil, err
+	}
+	engine.load = ruleset.Load()
+
+	engine.notify(notifs, away, false)
+	engine.flush()
+
+	return &result, nil
+}
+
+// Repo returns the configured repo for the given path.
+func (engine *Engine) Repo(path string) (Repo, bool) {
+	for _, repo := range engine.config.Repos {
+		if strings.EqualFold(repo.Path, path) {
+			return repo, true
+		}
+	}
+	return Repo{}, false
+}
+
+// Async executes the given function in the background such that it can be
+// waited on by Wait.
+func (engine *Engine) Async(fn func()) {
+	engine.tasks.Add(1)
+	go func() {
+		defer engine.tasks.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until all the background tasks have completed.
+func (engine *Engine) Wait() {
+	engine.tasks.Wait()
+}
+
+// SeedLoad computes the review load of the users if no job has run yet such
+// that the PRs processed by the webhooks account for the review caps and the
+// least-loaded strategy.
+func (engine *Engine) SeedLoad() {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	engine.seedLoad()
+}
+
+func (engine *Engine) seedLoad() {
+	if engine.load != nil {
+		return
+	}
+
+	Info("seeding the review load...")
+	ruleset := engine.newRuleset()
+	engine.query(ruleset)
+	engine.load = ruleset.Load()
+}
+
+// snapshot returns a ruleset that accounts for the review load of the last job
+// along with the users that are away. It allows the commands to query Github
+// without blocking the jobs and the webhooks.
+func (engine *Engine) snapshot() (*Ruleset, Set) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	ruleset := engine.newRuleset()
+	ruleset.SetLoad(engine.load)
+	away := engine.availability(ruleset)
+
+	return ruleset, away
+}
+
+// refresh clears the team cache and updates the team pools and the Slack users
+// such that changes made since the previous job are picked up. On error, the
+// previous pools and Slack users are kept.
+func (engine *Engine) refresh() {
+	ClearTeamCache()
+	engine.busy = nil
+
+	if err := engine.config.ExpandTeams(context.TODO(), engine.github); err != nil {
+		Warning("unable to refresh the pools: %v", err)
+	}
+
+	slackList, err := engine.slack.GetUsers()
+	if err != nil {
+		Warning("unable to refresh the slack users: %v", err)
+		return
+	}
+
+	slackUsers := SlackMapUsers(engine.config, slackList)
+
+	engine.slackLock.Lock()
+	engine.slackList = slackList
+	engine.slackUsers = slackUsers
+	engine.slackLock.Unlock()
+}
+
+// newRuleset returns a ruleset that accounts for the reviews recently
+// assigned by Gups.
+func (engine *Engine) newRuleset() *Ruleset {
+	ruleset := NewRuleset(engine.config)
+
+	since := time.Now().AddDate(0, 0, -engine.config.FairWindowDays)
+	history := make(map[string]int)
+	for _, assignment := range engine.store.Assignments(since) {
+		history[assignment.User]++
+	}
+	ruleset.SetHistory(history)
+
+	return ruleset
+}
+
+// query returns the open PRs of all the configured repos and accounts for
+// their pending review requests in the ruleset. Repos that can't be queried are
+// skipped.
+func (engine *Engine) query(ruleset *Ruleset) [][]*PullRequest {
+	config := engine.config
+	pullRequests := make([][]*PullRequest, len(config.Repos))
+
+	for index, repo := range config.Repos {
+		Info("[%v/%v] querying %v...", index+1, len(config.Repos), repo.Path)
+
+		prs, err := engine.queryRepo(ruleset, repo)
+		if err != nil {
+			Warning("skipping repo %v: %v", repo.Path, err)
+			continue
+		}
+
+		for _, pr := range prs {
+			ruleset.AddLoad(pr)
+		}
+		pullRequests[index] = prs
+	}
+
+	return pullRequests
+}
+
+// queryRepo returns the open PRs of the repo along with their code owners.
+func (engine *Engine) queryRepo(ruleset *Ruleset, repo Repo) ([]*PullRequest, error) {
+	vars := PathToVariables(repo.Path)
+
+	prs, err := engine.github.QueryPullRequests(context.TODO(), vars, ruleset.UsesCommits(repo.Rule))
+	if err != nil {
+		return nil, err
+	}
+
+	// CODEOWNERS are read from the base branch of each PR and queried once per
+	// base branch.
+	codeOwners := make(map[string]CodeOwners)
+
+	for _, pr := range prs {
+		owners, ok := codeOwners[pr.Base]
+		if !ok && (ruleset.UsesCodeOwners(repo.Rule) || pr.RequiresCodeOwners) {
+			if owners, err = engine.github.QueryCodeOwners(context.TODO(), vars, pr.Base); err != nil {
+				return nil, err
+			}
+			codeOwners[pr.Base] = owners
+		}
+
+		pr.CodeOwners = engine.github.ResolveOwners(context.TODO(), owners.Owners(pr.Files))
+	}
+
+	return prs, nil
+}
+
+// queryPullRequest returns the given PR of the repo along with its code owners
+// or nil if the PR is not open.
+func (engine *Engine) queryPullRequest(ruleset *Ruleset, repo Repo, number int) (*PullRequest, error) {
+	vars := PathToVariables(repo.Path)
+
+	pr, err := engine.github.QueryPullRequest(context.TODO(), vars, number, ruleset.UsesCommits(repo.Rule))
+	if err != nil || pr == nil {
+		return pr, err
+	}
+
+	if ruleset.UsesCodeOwners(repo.Rule) || pr.RequiresCodeOwners {
+		codeOwners, err := engine.github.QueryCodeOwners(context.TODO(), vars, pr.Base)
+		if err != nil {
+			return nil, err
+		}
+		pr.CodeOwners = engine.github.ResolveOwners(context.TODO(), codeOwners.Owners(pr.Files))
+	}
+
+	return pr, nil
+}
+
+// availability returns the set of users that are away and marks them as
+// unavailable in the ruleset. Users that are away according to their Slack
+// status are only excluded from new picks and are cached until the next job.
+func (engine *Engine) availability(ruleset *Ruleset) Set {
+	config := engine.config
+
+	if events, err := config.Availability.LoadICal(config.Serve.location); err != nil {
+		Warning("unable to read the availability calendar, keeping the previous one: %v", err)
+	} else {
+		engine.calendar = events
+	}
+
+	away := config.Availability.Unavailable(config, engine.calendar, time.Now().In(config.Serve.location))
+	if !away.Empty() {
+		Info("away users: %v", away)
+	}
+	ruleset.SetUnavailable(away)
+
+	if config.SlackStatus.Enabled() && engine.busy == nil {
+		busy, err := SlackUnavailable(engine.slack, config, engine.slackList, engine.slackUsers, time.Now())
+		if err != nil {
+			Warning("unable to get the slack status of users: %v", err)
+		} else {
+			engine.busy = busy
+		}
+	}
+
+	if engine.busy != nil {
+		ruleset.SetBusy(engine.busy)
+	}
+
+	return away
+}
+
+// process applies the rules to the PR, updates its review requests and returns
+// the result of the rules. If the review requests can't be updated then the PR
+// is skipped and an error is returned. The entries of the summary are only
+// added if digest is set, otherwise only the entries of the one-shot categories
+// are added.
+func (engine *Engine) process(
+	ruleset *Ruleset, repo Repo, pr *PullRequest, notifs UserNotifications, digest bool) (Result, error) {
+
+	result := ruleset.Apply(repo.Rule, pr)
+	if result.Skip {
+		return result, nil
+	}
+
+	if !result.New.Empty() {
+		Info("<%v> review request: %v", pr.Number, result.New)
+	}
+
+	if !result.Removed.Empty() {
+		Info("<%v> review request removed: %v", pr.Number, result.Removed)
+	}
+
+	if !result.Rereview.Empty() {
+		Info("<%v> review re-request: %v", pr.Number, result.Rereview)
+	}
+
+	if !result.New.Empty() || !result.Rereview.Empty() || !result.Removed.Empty() {
+		requests := pr.ReviewRequests.
+			Union(result.New).
+			Union(result.Rereview).
+			Difference(result.Removed).
+			ToArray()
+		teams := pr.ReviewTeams.ToArray()
+		if err := engine.github.RequestReview(context.TODO(), pr, requests, teams, engine.dryRun); err != nil {
+			return result, err
+		}
+	}
+
+	now := time.Now()
+
+	for user, _ := range result.New {
+		engine.store.AddAssignment(AssignmentRecord{
+			User:    user,
+			Path:    repo.Path,
+			Number:  int(pr.Number),
+			Ruleset: repo.Rule,
+			Rule:    result.Rule,
+			Time:    now,
+		})
+	}
+
+	for user, _ := range result.Escalated {
+		engine.store.AddEscalation(EscalationRecord{
+			User:     user,
+			Path:     repo.Path,
+			Number:   int(pr.Number),
+			Picked:   result.EscalatedTo[user].ToArray(),
+			Replaced: result.Removed.Test(user),
+			Time:     now,
+		})
+	}
+
+	for user, _ := range result.New {
+		notifs.Add(CategoryAssigned, user, repo.Path, pr)
+	}
+
+	for user, _ := range result.Rereview {
+		notifs.Add(CategoryRereview, user, repo.Path, pr)
+	}
+
+	for user, _ := range result.Escalated {
+		notifs.Add(CategoryEscalated, user, repo.Path, pr)
+	}
+
+	if digest {
+		engine.digest(ruleset, repo, pr, result, notifs)
+	}
+	return result, nil
+}
+
+// digest adds the entries of the full summary which only depend on the
+// current state of the PR.
+func (engine *Engine) digest(ruleset *Ruleset, repo Repo, pr *PullRequest, result Result, notifs UserNotifications) {
+	if result.Ready {
+		if ruleset.KnownUser(pr.Author) {
+			category := CategoryReady
+			if len(pr.Blockers()) > 0 {
+				category = CategoryBlocked
+			}
+			notifs.Add(category, pr.Author, repo.Path, pr)
+		}
+	} else {
+		if ruleset.KnownUser(pr.Author) {
+			category := CategoryOpen
+			if !result.AwaitingAuthor.Empty() {
+				category = CategoryAwaitingAuthor
+			}
+			notifs.Add(category, pr.Author, repo.Path, pr)
+		}
+		// Reviewers are listed under Pending until they review the PR, except
+		// on the run where they're assigned or re-requested which have their
+		// own category.
+		for user, _ := range result.Pending.Difference(result.New).Difference(result.Rereview) {
+			notifs.Add(CategoryPending, user, repo.Path, pr)
+		}
+	}
+
+	for user, _ := range result.Requested {
+		notifs.Add(CategoryRequested, user, repo.Path, pr)
+	}
+}
+
+// Snooze hides the PR from the notifications of the user until the given time.
+func (engine *Engine) Snooze(key EntryKey, until time.Time) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	engine.store.Snooze(key, until)
+	engine.flush()
+}
+
+// MarkReviewed hides the PR from the notifications of the user until new
+// commits are pushed to the PR. Returns false if the PR is not open.
+func (engine *Engine) MarkReviewed(key EntryKey) (bool, error) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	vars := PathToVariables(key.Path)
+	pr, err := engine.github.QueryPullRequest(context.TODO(), vars, key.Number, false)
+	if err != nil {
+		Warning("<%v> unable to query PR of %v: %v", key.Number, key.Path, err)
+		return false, err
+	}
+
+	if pr == nil {
+		return false, nil
+	}
+
+	engine.store.MarkReviewed(key, pr.Head)
+	engine.flush()
+	return true, nil
+}
+
+// lastNotified returns the category group of the latest entry sent for each
+// user and PR.
+func (engine *Engine) lastNotified() map[EntryKey]Category {
+	result := make(map[EntryKey]Category)
+
+	for _, record := range engine.store.Notifications(time.Time{}) {
+		if cat, ok := CategoryByName(record.Category); ok {
+			key := EntryKey{User: record.User, Path: record.Path, Number: record.Number}
+			result[key] = cat.Group()
+		}
+	}
+
+	return result
+}
+
+// filter removes the snoozed and reviewed entries from the notifications of the
+// user. Unless full is set, the entries of the summary are only kept if their
+// category changed since the last entry sent to the user for the PR. Entries
+// that were never sent are kept if the state store is persistent, otherwise
+// they're only kept if the PR changed since the previous run according to
+// Github. Entries of one-shot categories are always kept as they are triggered
+// by a change to the review requests of the PR.
+func (engine *Engine) filter(user string, notif Notifications, full bool, last map[EntryKey]Category) Notifications {
+	now := time.Now()
+
+	var result Notifications
+	for _, entry := range notif {
+		key := EntryKey{User: user, Path: entry.Path, Number: int(entry.PR.Number)}
+
+		if until, ok := engine.store.Snoozed(key); ok && now.Before(until) {
+			continue
+		}
+
+		if head, ok := engine.store.Reviewed(key); ok {
+			if head == entry.PR.Head {
+				continue
+			}
+			engine.store.MarkReviewed(key, "")
+		}
+
+		if !full && !entry.Category.OneShot() {
+			if cat, ok := last[key]; ok {
+				if cat == entry.Category.Group() {
+					continue
+				}
+			} else if !engine.config.State.Persistent() && !entry.PR.UpdatedAt(user).After(engine.since) {
+				continue
+			}
+		}
+
+		result = append(result, entry)
+	}
+
+	return result
+}
+
+func (engine *Engine) flush() {
+	if err := engine.store.Flush(); err != nil {
+		Warning("unable to flush the state store: %v", err)
+	}
+}
+
+func (engine *Engine) notify(notifs UserNotifications, away Set, full bool) {
+	last := engine.lastNotified()
+
+	index := 0
+	for githubUser, notif := range notifs {
+		Info("[%v/%v] notifying %v...", index+1, len(notifs), githubUser)
+
+		notif = engine.filter(githubUser, notif, full, last)
+
+		if len(notif) == 0 {
+			Info("skipping user '%v' with no notifications", githubUser)
+		} else if away.Test(githubUser) {
+			Info("skipping away user '%v'", githubUser)
+		} else if slackUser, ok := engine.slackUsers[githubUser]; ok {
+			if err := NotifySlack(engine.slack, slackUser, notif, engine.interactive, engine.dryRun); err != nil {
+				Warning("unable to notify slack user '%v': %v", githubUser, err)
+				index++
+				continue
+			}
+
+			now := time.Now()
+			for _, entry := range notif {
+				engine.store.AddNotification(NotificationRecord{
+					User:     githubUser,
+					Path:     entry.Path,
+					Number:   int(entry.PR.Number),
+					Category: entry.Category.Name(),
+					Time:     now,
+				})
+			}
+		} else {
+			Warning("unconfigured github user '%v'", githubUser)
+		}
+
+		index++
+	}
+}
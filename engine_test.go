@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+func TestNotificationFilter(t *testing.T) {
+	Debug("[ notification filter ]=================================")
+
+	config := &Config{State: StateConfig{Store: StoreJSON}}
+	engine := &Engine{config: config, store: NewMemoryStore(0)}
+	now := time.Now()
+
+	pr1 := PR("pr1", "u1").Commit("c1", false)
+	pr1.Number = 1
+	pr2 := PR("pr2", "u1")
+	pr2.Number = 2
+
+	notif := Notifications{
+		{Category: CategoryAssigned, Path: "org/repo", PR: pr1},
+		{Category: CategoryPending, Path: "org/repo", PR: pr2},
+	}
+
+	check := func(title string, full bool, exp ...Category) {
+		t.Helper()
+
+		result := engine.filter("u2", notif, full, engine.lastNotified())
+		if len(result) != len(exp) {
+			t.Errorf("%v: val=%v exp=%v", title, len(result), len(exp))
+			return
+		}
+
+		for i, entry := range result {
+			if entry.Category != exp[i] {
+				t.Errorf("%v-%v: val=%v exp=%v", title, i, entry.Category, exp[i])
+			}
+		}
+	}
+
+	record := func(number int, cat Category) {
+		engine.store.AddNotification(NotificationRecord{
+			User: "u2", Path: "org/repo", Number: number, Category: cat.Name(), Time: now,
+		})
+	}
+
+	check("empty", false, CategoryAssigned, CategoryPending)
+	check("empty-full", true, CategoryAssigned, CategoryPending)
+
+	record(2, CategoryAssigned)
+	check("unchanged", false, CategoryAssigned)
+
+	record(2, CategoryReady)
+	check("changed", false, CategoryAssigned, CategoryPending)
+
+	record(2, CategoryPending)
+	check("notified", false, CategoryAssigned)
+
+	engine.store.Snooze(EntryKey{User: "u2", Path: "org/repo", Number: 1}, now.Add(time.Hour))
+	check("snoozed", true, CategoryPending)
+
+	engine.store.Snooze(EntryKey{User: "u2", Path: "org/repo", Number: 1}, now.Add(-time.Hour))
+	engine.store.MarkReviewed(EntryKey{User: "u2", Path: "org/repo", Number: 1}, "c1")
+	check("reviewed", true, CategoryPending)
+
+	pr1.Commit("c2", false)
+	check("pushed", true, CategoryAssigned, CategoryPending)
+
+	// Without a persistent store, entries that were never sent are only kept
+	// if the PR changed since the previous run according to Github.
+	config.State.Store = StoreMemory
+	engine.store = NewMemoryStore(0)
+	engine.since = now.Add(-time.Hour)
+	pr2.Created = now.Add(-48 * time.Hour)
+	pr2.HeadAt = now.Add(-24 * time.Hour)
+
+	check("stateless-unchanged", false, CategoryAssigned)
+
+	pr2.RequestAt("u2", now.Add(-2*time.Hour))
+	check("stateless-old-request", false, CategoryAssigned)
+
+	pr2.RequestAt("u2", now.Add(-time.Minute))
+	check("stateless-request", false, CategoryAssigned, CategoryPending)
+
+	pr2.RequestedAt["u2"] = now.Add(-2 * time.Hour)
+	pr2.ReviewAs("u3", ReviewApproved, now.Add(-time.Minute))
+	check("stateless-review", false, CategoryAssigned, CategoryPending)
+
+	pr2.Reviews = nil
+	pr2.HeadAt = now.Add(-time.Minute)
+	check("stateless-commit", false, CategoryAssigned, CategoryPending)
+
+	record(2, CategoryPending)
+	check("stateless-notified", false, CategoryAssigned)
+}
+
+func TestFairHistory(t *testing.T) {
+	Debug("[ fair history ]========================================")
+
+	config := ParseConfig("test", []byte(`
+{
+    "repos": [{ "path": "org/repo", "rule": "r1" }],
+    "github_to_slack_user": { "u1": "s1", "u2": "s2", "u3": "s3", "u4": "s4" },
+    "pools": { "p1": [ "u2", "u3", "u4" ] },
+    "ruleset": { "r1": [{ "pick": ["p1:1:fair"] }] },
+    "fair_window_days": 7,
+    "state": { "store": "memory", "retention_days": 30 }
+}`))
+
+	engine := &Engine{config: config, store: NewMemoryStore(0)}
+	now := time.Now()
+
+	assign := func(user string, days int) {
+		engine.store.AddAssignment(AssignmentRecord{
+			User: user, Path: "org/repo", Number: 1, Ruleset: "r1", Time: now.AddDate(0, 0, -days),
+		})
+	}
+
+	assign("u2", 0)
+	assign("u2", 6)
+	assign("u3", 1)
+	assign("u3", 8)
+	assign("u4", 8)
+	assign("u4", 20)
+
+	ruleset := engine.newRuleset()
+	for user, exp := range map[string]int{"u2": 2, "u3": 1, "u4": 0} {
+		if val := ruleset.history[user]; val != exp {
+			t.Errorf("history %v: val=%v exp=%v", user, val, exp)
+		}
+	}
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1"),
+		New("u4"), Pending("u4"), Assigned("u4"), Requested(), Ready(false))
+}
+
+func TestWebhookRun(t *testing.T) {
+	Debug("[ webhook run ]=========================================")
+
+	config := ParseConfig("test", []byte(`
+{
+    "repos": [{ "path": "org/repo", "rule": "r1" }],
+    "github_to_slack_user": { "u1": "s1", "u2": "s2", "u3": "s3" },
+    "pools": { "p1": [ "u2" ], "p2": [ "u3" ] },
+    "ruleset": { "r1": [{ "pick": ["p1", "p2"] }] }
+}`))
+
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "user(login:") {
+			w.Write([]byte(`{"data": {"user": {"id": "id"}}}`))
+			return
+		}
+
+		// The PR was just created such that its entries are considered changed
+		// since the previous run.
+		fmt.Fprintf(w, `{"data": {"repository": {"pullRequest": {
+            "state": "OPEN", "id": "pr1", "number": 1, "title": "pr1", "createdAt": "%v",
+            "author": {"login": "u1"},
+            "reviewRequests": {"nodes": [{"requestedReviewer": {"login": "u2"}}]}
+        }}}}`, time.Now().Format(time.RFC3339))
+	}))
+	defer github.Close()
+
+	quotes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("meep"))
+	}))
+	defer quotes.Close()
+
+	defer func(url string) { InspirationURL = url }(InspirationURL)
+	InspirationURL = quotes.URL
+
+	engine := &Engine{
+		config:     config,
+		github:     (*GithubClient)(githubv4.NewEnterpriseClient(github.URL, github.Client())),
+		slackUsers: SlackUsers{"u1": "S1", "u2": "S2", "u3": "S3"},
+		dryRun:     true,
+		store:      NewMemoryStore(0),
+		load:       make(map[string]int),
+	}
+
+	repo, _ := engine.Repo("org/repo")
+	result, err := engine.RunPullRequest(repo, 1, NewSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	CheckSet(t, "new", NewSet("u3"), result.New)
+	CheckSet(t, "pending", NewSet("u2", "u3"), result.Pending)
+
+	sent := make(map[string]string)
+	for _, record := range engine.store.Notifications(time.Time{}) {
+		sent[record.User] = record.Category
+	}
+
+	exp := map[string]string{"u3": CategoryAssigned.Name()}
+	if !reflect.DeepEqual(sent, exp) {
+		t.Errorf("sent: val=%v exp=%v", sent, exp)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Condition is a compiled rule condition expression of the form:
+//
+//	expr  := and ( '||' and )*
+//	and   := unary ( '&&' unary )*
+//	unary := '!' unary | '(' expr ')' | term
+//	term  := 'author' 'in' <pool>
+//	       | 'label' '(' <string> ')'
+//	       | 'files' '(' <string> ( ',' <string> )* ')'
+//	       | 'draft' | 'true' | 'false'
+type Condition interface {
+	Eval(ruleset *Ruleset, pr *PullRequest) bool
+}
+
+type condAnd struct{ lhs, rhs Condition }
+type condOr struct{ lhs, rhs Condition }
+type condNot struct{ cond Condition }
+type condAuthorIn struct{ pool string }
+type condLabel struct{ label string }
+type condFiles struct{ patterns []string }
+type condDraft struct{}
+type condBool struct{ value bool }
+
+func (cond condAnd) Eval(ruleset *Ruleset, pr *PullRequest) bool {
+	return cond.lhs.Eval(ruleset, pr) && cond.rhs.Eval(ruleset, pr)
+}
+
+func (cond condOr) Eval(ruleset *Ruleset, pr *PullRequest) bool {
+	return cond.lhs.Eval(ruleset, pr) || cond.rhs.Eval(ruleset, pr)
+}
+
+func (cond condNot) Eval(ruleset *Ruleset, pr *PullRequest) bool {
+	return !cond.cond.Eval(ruleset, pr)
+}
+
+func (cond condAuthorIn) Eval(ruleset *Ruleset, pr *PullRequest) bool {
+	return ruleset.pool(cond.pool, pr).Test(pr.Author)
+}
+
+func (cond condLabel) Eval(ruleset *Ruleset, pr *PullRequest) bool {
+	return pr.Labels.Test(cond.label)
+}
+
+func (cond condFiles) Eval(ruleset *Ruleset, pr *PullRequest) bool {
+	return pr.Touches(cond.patterns)
+}
+
+func (cond condDraft) Eval(ruleset *Ruleset, pr *PullRequest) bool {
+	return pr.Draft
+}
+
+func (cond condBool) Eval(ruleset *Ruleset, pr *PullRequest) bool {
+	return cond.value
+}
+
+// condUsesPool indicates whether the condition references the given pool in an
+// 'author in' term.
+func condUsesPool(cond Condition, pool string) bool {
+	switch cond := cond.(type) {
+	case condAnd:
+		return condUsesPool(cond.lhs, pool) || condUsesPool(cond.rhs, pool)
+	case condOr:
+		return condUsesPool(cond.lhs, pool) || condUsesPool(cond.rhs, pool)
+	case condNot:
+		return condUsesPool(cond.cond, pool)
+	case condAuthorIn:
+		return cond.pool == pool
+	}
+	return false
+}
+
+type ExprError struct {
+	Pos int
+	Msg string
+}
+
+func (err *ExprError) Error() string {
+	return fmt.Sprintf("column %v: %v", err.Pos+1, err.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (tok token) String() string {
+	switch tok.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(tok.value)
+	}
+	return fmt.Sprintf("'%v'", tok.value)
+}
+
+func isIdentRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') ||
+		strings.ContainsRune("-_./@", r)
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+
+	for pos := 0; pos < len(expr); {
+		r := rune(expr[pos])
+
+		switch {
+		case unicode.IsSpace(r):
+			pos++
+
+		case r == '(':
+			tokens = append(tokens, token{tokenLParen, "(", pos})
+			pos++
+
+		case r == ')':
+			tokens = append(tokens, token{tokenRParen, ")", pos})
+			pos++
+
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", pos})
+			pos++
+
+		case r == '!':
+			tokens = append(tokens, token{tokenNot, "!", pos})
+			pos++
+
+		case strings.HasPrefix(expr[pos:], "&&"):
+			tokens = append(tokens, token{tokenAnd, "&&", pos})
+			pos += 2
+
+		case strings.HasPrefix(expr[pos:], "||"):
+			tokens = append(tokens, token{tokenOr, "||", pos})
+			pos += 2
+
+		case r == '"':
+			end := pos + 1
+			for ; end < len(expr) && expr[end] != '"'; end++ {
+				if expr[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, &ExprError{pos, "unterminated string"}
+			}
+
+			value, err := strconv.Unquote(expr[pos : end+1])
+			if err != nil {
+				return nil, &ExprError{pos, fmt.Sprintf("malformed string: %v", err)}
+			}
+
+			tokens = append(tokens, token{tokenString, value, pos})
+			pos = end + 1
+
+		case isIdentRune(r):
+			end := pos
+			for end < len(expr) && isIdentRune(rune(expr[end])) {
+				end++
+			}
+
+			tokens = append(tokens, token{tokenIdent, expr[pos:end], pos})
+			pos = end
+
+		default:
+			return nil, &ExprError{pos, fmt.Sprintf("unexpected character '%c'", r)}
+		}
+	}
+
+	return append(tokens, token{tokenEOF, "", len(expr)}), nil
+}
+
+type exprParser struct {
+	tokens []token
+	pools  Set
+}
+
+func (parser *exprParser) peek() token {
+	return parser.tokens[0]
+}
+
+func (parser *exprParser) next() token {
+	tok := parser.tokens[0]
+	if tok.kind != tokenEOF {
+		parser.tokens = parser.tokens[1:]
+	}
+	return tok
+}
+
+func (parser *exprParser) expect(kind tokenKind, what string) (token, error) {
+	tok := parser.next()
+	if tok.kind != kind {
+		return tok, &ExprError{tok.pos, fmt.Sprintf("expected %v but got %v", what, tok)}
+	}
+	return tok, nil
+}
+
+// ParseCondition compiles the given expression where pools is used to validate
+// the pools referenced by 'author in' terms.
+func ParseCondition(expr string, pools Set) (Condition, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &exprParser{tokens: tokens, pools: pools}
+	cond, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := parser.peek(); tok.kind != tokenEOF {
+		return nil, &ExprError{tok.pos, fmt.Sprintf("unexpected %v", tok)}
+	}
+
+	return cond, nil
+}
+
+func (parser *exprParser) parseOr() (Condition, error) {
+	lhs, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.peek().kind == tokenOr {
+		parser.next()
+
+		rhs, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		lhs = condOr{lhs, rhs}
+	}
+
+	return lhs, nil
+}
+
+func (parser *exprParser) parseAnd() (Condition, error) {
+	lhs, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.peek().kind == tokenAnd {
+		parser.next()
+
+		rhs, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = condAnd{lhs, rhs}
+	}
+
+	return lhs, nil
+}
+
+func (parser *exprParser) parseUnary() (Condition, error) {
+	switch parser.peek().kind {
+
+	case tokenNot:
+		parser.next()
+
+		cond, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return condNot{cond}, nil
+
+	case tokenLParen:
+		parser.next()
+
+		cond, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := parser.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return cond, nil
+	}
+
+	return parser.parseTerm()
+}
+
+func (parser *exprParser) parseTerm() (Condition, error) {
+	tok, err := parser.expect(tokenIdent, "a condition")
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok.value {
+
+	case "true", "false":
+		return condBool{tok.value == "true"}, nil
+
+	case "draft":
+		return condDraft{}, nil
+
+	case "author":
+		if in := parser.next(); in.kind != tokenIdent || in.value != "in" {
+			return nil, &ExprError{in.pos, fmt.Sprintf("expected 'in' but got %v", in)}
+		}
+
+		pool, err := parser.expect(tokenIdent, "a pool name")
+		if err != nil {
+			return nil, err
+		}
+
+		if !parser.pools.Test(pool.value) {
+			return nil, &ExprError{pool.pos, fmt.Sprintf("unknown pool '%v'", pool.value)}
+		}
+		return condAuthorIn{pool.value}, nil
+
+	case "label":
+		args, err := parser.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(args) != 1 {
+			return nil, &ExprError{tok.pos, "label expects exactly one argument"}
+		}
+		return condLabel{args[0]}, nil
+
+	case "files":
+		args, err := parser.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pattern := range args {
+			if !ValidGlob(pattern) {
+				return nil, &ExprError{tok.pos, fmt.Sprintf("malformed pattern '%v'", pattern)}
+			}
+		}
+		return condFiles{args}, nil
+	}
+
+	return nil, &ExprError{tok.pos, fmt.Sprintf("unknown condition '%v'", tok.value)}
+}
+
+func (parser *exprParser) parseArgs() ([]string, error) {
+	if _, err := parser.expect(tokenLParen, "'('"); err != nil {
+		return nil, err
+	}
+
+	var args []string
+	for {
+		arg, err := parser.expect(tokenString, "a string")
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg.value)
+
+		if parser.peek().kind != tokenComma {
+			break
+		}
+		parser.next()
+	}
+
+	if _, err := parser.expect(tokenRParen, "')'"); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
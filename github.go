@@ -8,6 +8,8 @@ import (
 	"golang.org/x/oauth2"
 	"os"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -31,12 +33,23 @@ const (
 	labelCount     = 50
 	reviewCount    = 50
 	reviewReqCount = 50
+	fileCount      = 100
+	timelineCount  = 100
+	commitCount    = 100
+
+	teamMemberCount = 100
 )
 
 type Review struct {
 	Author string
 	State  string
 	Time   time.Time
+	Commit string
+}
+
+type Commit struct {
+	Oid   string
+	Merge bool
 }
 
 type Reviews []Review
@@ -78,171 +91,736 @@ type PullRequest struct {
 	Title  string
 	Author string
 	Age    Age
+	Draft  bool
 
 	Labels         Set
 	Reviews        Reviews
 	ReviewRequests Set
+
+	ReviewTeams        Set
+	TeamReviewRequests Set
+
+	// Base is the name of the branch the PR is merged into.
+	Base string
+
+	Files      []string
+	CodeOwners Set
+
+	Head    string
+	Commits []Commit
+
+	// Created and HeadAt are the times at which the PR was opened and its head
+	// commit was committed.
+	Created time.Time
+	HeadAt  time.Time
+
+	Mergeable      string
+	ReviewDecision string
+	Checks         string
+
+	RequiredApprovals  int
+	RequiresCodeOwners bool
+
+	RequestedAt map[string]time.Time
 }
 
-func (pr PullRequest) Reviewed() Set {
-	reviewed := NewSet()
-	pending := NewSet()
+// UpdatedAt returns the time of the latest change to the PR that concerns the
+// given user according to Github: the PR was opened, a commit was made, a review
+// was submitted or the user was requested to review the PR.
+func (pr PullRequest) UpdatedAt(user string) time.Time {
+	result := pr.Created
+
+	for _, ts := range []time.Time{pr.HeadAt, pr.RequestedAt[user]} {
+		if ts.After(result) {
+			result = ts
+		}
+	}
+
+	for _, review := range pr.Reviews {
+		if review.Time.After(result) {
+			result = review.Time
+		}
+	}
+
+	return result
+}
+
+// Review states as reported by Github. Commented and pending reviews don't
+// affect the outcome of a previous review.
+const (
+	ReviewApproved         = "APPROVED"
+	ReviewChangesRequested = "CHANGES_REQUESTED"
+	ReviewCommented        = "COMMENTED"
+	ReviewDismissed        = "DISMISSED"
+	ReviewPending          = "PENDING"
+)
+
+// LatestReviews returns the latest review of each reviewer that affects the
+// outcome of the PR.
+func (pr PullRequest) LatestReviews() map[string]Review {
+	latest := make(map[string]Review)
 
 	for _, review := range pr.Reviews {
-		if reviewed.Test(review.Author) || pending.Test(review.Author) {
+		if review.State == ReviewCommented || review.State == ReviewPending {
 			continue
 		}
 
-		if review.State == "APPROVED" {
-			reviewed.Put(review.Author)
-		} else {
-			pending.Put(review.Author)
+		if _, ok := latest[review.Author]; !ok {
+			latest[review.Author] = review
 		}
 	}
 
+	return latest
+}
+
+func (pr PullRequest) Reviewed() Set {
+	reviewed := NewSet()
+	for user, review := range pr.LatestReviews() {
+		if review.State == ReviewApproved {
+			reviewed.Put(user)
+		}
+	}
 	return reviewed
 }
 
+// Protected returns true if the given approvals satisfy the review requirements
+// of the base branch protection rule.
+func (pr PullRequest) Protected(approved Set) bool {
+	approved = approved.Difference(NewSet(pr.Author))
+	if len(approved) < pr.RequiredApprovals {
+		return false
+	}
+
+	owners := pr.CodeOwners.Fold()
+	if pr.RequiresCodeOwners && !owners.Empty() && approved.Fold().Intersect(owners).Empty() {
+		return false
+	}
+
+	return true
+}
+
+// Blockers returns the reasons, as reported by Github, that prevent the PR from
+// being merged.
+func (pr PullRequest) Blockers() []string {
+	var blockers []string
+
+	switch pr.Checks {
+	case "FAILURE", "ERROR":
+		blockers = append(blockers, "CI failing")
+	case "PENDING", "EXPECTED":
+		blockers = append(blockers, "CI pending")
+	}
+
+	if pr.Mergeable == "CONFLICTING" {
+		blockers = append(blockers, "merge conflicts")
+	}
+
+	switch pr.ReviewDecision {
+	case "CHANGES_REQUESTED":
+		blockers = append(blockers, "changes requested")
+	case "REVIEW_REQUIRED":
+		blockers = append(blockers, "review required")
+	}
+
+	return blockers
+}
+
+// StaleApprovals returns the reviewers whose approval was submitted against a
+// commit older than the head of the PR. When ignoreMerges is set, approvals only
+// followed by merge commits (e.g. merging the base branch) are not stale.
+func (pr PullRequest) StaleApprovals(ignoreMerges bool) Set {
+	stale := NewSet()
+
+	for user, review := range pr.LatestReviews() {
+		if review.State != ReviewApproved || review.Commit == pr.Head {
+			continue
+		}
+
+		index := -1
+		for i, commit := range pr.Commits {
+			if commit.Oid == review.Commit {
+				index = i
+			}
+		}
+
+		if index < 0 || !ignoreMerges {
+			stale.Put(user)
+			continue
+		}
+
+		for _, commit := range pr.Commits[index+1:] {
+			if !commit.Merge {
+				stale.Put(user)
+				break
+			}
+		}
+	}
+
+	return stale
+}
+
+// ChangesRequested returns the reviewers that requested changes and whether the
+// ball is still in the author's court: the review was submitted against the
+// head of the PR, meaning that no commits were pushed or force-pushed since,
+// and the review wasn't requested again.
+func (pr PullRequest) ChangesRequested() (requested, awaiting Set) {
+	requested = NewSet()
+	awaiting = NewSet()
+
+	for user, review := range pr.LatestReviews() {
+		if review.State != ReviewChangesRequested {
+			continue
+		}
+
+		requested.Put(user)
+		if !pr.ReviewRequests.Test(user) && review.Commit == pr.Head {
+			awaiting.Put(user)
+		}
+	}
+
+	return requested, awaiting
+}
+
+func (pr PullRequest) Touches(patterns []string) bool {
+	for _, file := range pr.Files {
+		for _, pattern := range patterns {
+			if MatchGlob(pattern, file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type Variables struct {
 	Owner      string
 	Repository string
 }
 
+type pageInfo struct {
+	HasNextPage githubv4.Boolean
+	EndCursor   githubv4.String
+}
+
+type rawLabel struct {
+	Name githubv4.String
+}
+
+type rawReview struct {
+	State       githubv4.String
+	SubmittedAt githubv4.DateTime
+	Author      struct {
+		Login githubv4.String
+	}
+	Commit struct {
+		Oid githubv4.String
+	}
+}
+
+type rawCommit struct {
+	Commit struct {
+		Oid     githubv4.String
+		Parents struct {
+			TotalCount githubv4.Int
+		}
+	}
+}
+
+type rawFile struct {
+	Path githubv4.String
+}
+
+type rawReviewRequest struct {
+	RequestedReviewer struct {
+		User struct {
+			Login githubv4.String
+		} `graphql:"... on User"`
+		Team struct {
+			CombinedSlug githubv4.String
+		} `graphql:"... on Team"`
+	}
+}
+
+type rawTimelineItem struct {
+	ReviewRequestedEvent struct {
+		CreatedAt         githubv4.DateTime
+		RequestedReviewer struct {
+			User struct {
+				Login githubv4.String
+			} `graphql:"... on User"`
+		}
+	} `graphql:"... on ReviewRequestedEvent"`
+}
+
+type rawPullRequest struct {
+	Id        githubv4.String
+	Number    githubv4.Int
+	CreatedAt githubv4.DateTime
+	Title     githubv4.String
+	IsDraft   githubv4.Boolean
+	Author    struct {
+		Login githubv4.String
+	}
+
+	Labels struct {
+		PageInfo pageInfo
+		Nodes    []rawLabel
+	} `graphql:"labels(first: $labelCount)"`
+
+	Reviews struct {
+		PageInfo pageInfo
+		Nodes    []rawReview
+	} `graphql:"reviews(first: $reviewCount)"`
+
+	ReviewRequests struct {
+		PageInfo pageInfo
+		Nodes    []rawReviewRequest
+	} `graphql:"reviewRequests(first: $reviewReqCount)"`
+
+	Files struct {
+		PageInfo pageInfo
+		Nodes    []rawFile
+	} `graphql:"files(first: $fileCount)"`
+
+	TimelineItems struct {
+		PageInfo pageInfo
+		Nodes    []rawTimelineItem
+	} `graphql:"timelineItems(itemTypes: [REVIEW_REQUESTED_EVENT], first: $timelineCount)"`
+
+	HeadRefOid githubv4.String
+	Commits    struct {
+		PageInfo pageInfo
+		Nodes    []rawCommit
+	} `graphql:"commits(first: $commitCount) @include(if: $withCommits)"`
+
+	BaseRef struct {
+		Name                 githubv4.String
+		BranchProtectionRule struct {
+			RequiresApprovingReviews     githubv4.Boolean
+			RequiredApprovingReviewCount githubv4.Int
+			RequiresCodeOwnerReviews     githubv4.Boolean
+		}
+	}
+
+	Mergeable      githubv4.String
+	ReviewDecision githubv4.String
+	HeadCommit     struct {
+		Nodes []struct {
+			Commit struct {
+				CommittedDate     githubv4.DateTime
+				StatusCheckRollup struct {
+					State githubv4.String
+				}
+			}
+		}
+	} `graphql:"headCommit: commits(last: 1)"`
+}
+
 type queryPR struct {
 	Repository struct {
 		PullRequests struct {
-			TotalCount githubv4.Int
-			Nodes      []struct {
-				Id        githubv4.String
-				Number    githubv4.Int
-				CreatedAt githubv4.DateTime
-				Title     githubv4.String
-				Author    struct {
-					Login githubv4.String
-				}
+			PageInfo pageInfo
+			Nodes    []rawPullRequest
+		} `graphql:"pullRequests(states: OPEN, first: $prCount, after: $prCursor)"`
+	} `graphql:"repository(owner: $owner, name: $repo)"`
+}
 
-				Labels struct {
-					TotalCount githubv4.Int
-					Nodes      []struct {
-						Name githubv4.String
-					}
-				} `graphql:"labels(first: $labelCount)"`
-
-				Reviews struct {
-					TotalCount githubv4.Int
-					Nodes      []struct {
-						State       githubv4.String
-						SubmittedAt githubv4.DateTime
-						Author      struct {
-							Login githubv4.String
-						}
-					}
-				} `graphql:"reviews(first: $reviewCount)"`
-
-				ReviewRequests struct {
-					TotalCount githubv4.Int
-					Nodes      []struct {
-						RequestedReviewer struct {
-							User struct {
-								Login githubv4.String
-							} `graphql:"... on User"`
-						}
-					}
-				} `graphql:"reviewRequests(first: $reviewReqCount)"`
-			}
-		} `graphql:"pullRequests(states: OPEN, first: $prCount)"`
+type queryOnePR struct {
+	Repository struct {
+		PullRequest struct {
+			State githubv4.PullRequestState
+			rawPullRequest
+		} `graphql:"pullRequest(number: $number)"`
 	} `graphql:"repository(owner: $owner, name: $repo)"`
 }
 
-func (client *GithubClient) QueryPullRequests(ctx context.Context, vars Variables) []*PullRequest {
-	variables := map[string]interface{}{
+type queryPRLabels struct {
+	Node struct {
+		PullRequest struct {
+			Labels struct {
+				PageInfo pageInfo
+				Nodes    []rawLabel
+			} `graphql:"labels(first: $count, after: $cursor)"`
+		} `graphql:"... on PullRequest"`
+	} `graphql:"node(id: $id)"`
+}
+
+type queryPRReviews struct {
+	Node struct {
+		PullRequest struct {
+			Reviews struct {
+				PageInfo pageInfo
+				Nodes    []rawReview
+			} `graphql:"reviews(first: $count, after: $cursor)"`
+		} `graphql:"... on PullRequest"`
+	} `graphql:"node(id: $id)"`
+}
+
+type queryPRReviewRequests struct {
+	Node struct {
+		PullRequest struct {
+			ReviewRequests struct {
+				PageInfo pageInfo
+				Nodes    []rawReviewRequest
+			} `graphql:"reviewRequests(first: $count, after: $cursor)"`
+		} `graphql:"... on PullRequest"`
+	} `graphql:"node(id: $id)"`
+}
+
+type queryPRFiles struct {
+	Node struct {
+		PullRequest struct {
+			Files struct {
+				PageInfo pageInfo
+				Nodes    []rawFile
+			} `graphql:"files(first: $count, after: $cursor)"`
+		} `graphql:"... on PullRequest"`
+	} `graphql:"node(id: $id)"`
+}
+
+type queryPRTimelineItems struct {
+	Node struct {
+		PullRequest struct {
+			TimelineItems struct {
+				PageInfo pageInfo
+				Nodes    []rawTimelineItem
+			} `graphql:"timelineItems(itemTypes: [REVIEW_REQUESTED_EVENT], first: $count, after: $cursor)"`
+		} `graphql:"... on PullRequest"`
+	} `graphql:"node(id: $id)"`
+}
+
+type queryPRCommits struct {
+	Node struct {
+		PullRequest struct {
+			Commits struct {
+				PageInfo pageInfo
+				Nodes    []rawCommit
+			} `graphql:"commits(first: $count, after: $cursor)"`
+		} `graphql:"... on PullRequest"`
+	} `graphql:"node(id: $id)"`
+}
+
+func pageVariables(id string, count int, cursor githubv4.String) map[string]interface{} {
+	return map[string]interface{}{
+		"id":     githubv4.ID(id),
+		"count":  githubv4.Int(count),
+		"cursor": cursor,
+	}
+}
+
+// prVariables returns the variables of the PR queries where the commits of the
+// PRs are only queried if withCommits is set.
+func prVariables(vars Variables, withCommits bool) map[string]interface{} {
+	return map[string]interface{}{
 		"owner":          githubv4.String(vars.Owner),
 		"repo":           githubv4.String(vars.Repository),
-		"prCount":        githubv4.Int(prCount),
 		"labelCount":     githubv4.Int(labelCount),
 		"reviewCount":    githubv4.Int(reviewCount),
 		"reviewReqCount": githubv4.Int(reviewReqCount),
+		"fileCount":      githubv4.Int(fileCount),
+		"timelineCount":  githubv4.Int(timelineCount),
+		"commitCount":    githubv4.Int(commitCount),
+		"withCommits":    githubv4.Boolean(withCommits),
+	}
+}
+
+func (client *GithubClient) QueryPullRequests(
+	ctx context.Context, vars Variables, withCommits bool) ([]*PullRequest, error) {
+
+	variables := prVariables(vars, withCommits)
+	variables["prCount"] = githubv4.Int(prCount)
+	variables["prCursor"] = (*githubv4.String)(nil)
+
+	var pullRequests []*PullRequest
+	for {
+		if false { // DEBUG
+			bytes, _ := json.MarshalIndent(variables, "", "    ")
+			Debug("Vars: %v", string(bytes))
+		}
+
+		var raw queryPR
+		if err := client.cast().Query(ctx, &raw, variables); err != nil {
+			return nil, err
+		}
+
+		for _, rawPullRequest := range raw.Repository.PullRequests.Nodes {
+			pr, err := client.newPullRequest(ctx, rawPullRequest)
+			if err != nil {
+				return nil, err
+			}
+			pullRequests = append(pullRequests, pr)
+		}
+
+		page := raw.Repository.PullRequests.PageInfo
+		if !page.HasNextPage {
+			break
+		}
+		variables["prCursor"] = githubv4.NewString(page.EndCursor)
 	}
 
 	if false { // DEBUG
-		bytes, _ := json.MarshalIndent(variables, "", "    ")
-		Debug("Vars: %v", string(bytes))
+		bytes, _ := json.MarshalIndent(pullRequests, "", "    ")
+		Debug("PullRequests: %v", string(bytes))
 	}
 
-	var raw queryPR
+	return pullRequests, nil
+}
+
+// QueryPullRequest returns the given PR of the repo or nil if the PR is not
+// open.
+func (client *GithubClient) QueryPullRequest(
+	ctx context.Context, vars Variables, number int, withCommits bool) (*PullRequest, error) {
+
+	variables := prVariables(vars, withCommits)
+	variables["number"] = githubv4.Int(number)
+
+	var raw queryOnePR
 	if err := client.cast().Query(ctx, &raw, variables); err != nil {
-		Fatal("unable to query github: %v", err)
-		return nil
+		return nil, err
 	}
 
-	if count := raw.Repository.PullRequests.TotalCount; count > prCount {
-		Warning("Truncated PR result for %v/%v (%v > %v)",
-			vars.Owner, vars.Repository, count, prCount)
+	if raw.Repository.PullRequest.State != githubv4.PullRequestStateOpen {
+		return nil, nil
 	}
 
-	var pullRequests []*PullRequest
-	for _, rawPullRequest := range raw.Repository.PullRequests.Nodes {
+	return client.newPullRequest(ctx, raw.Repository.PullRequest.rawPullRequest)
+}
+
+// PullRequestRef identifies a PR found through the Github search.
+type PullRequestRef struct {
+	Path   string
+	Number int
+}
+
+// SearchPullRequests returns the open PRs that the user authored, was requested
+// to review or reviewed.
+func (client *GithubClient) SearchPullRequests(ctx context.Context, user string) ([]PullRequestRef, error) {
+	known := NewSet()
+	var refs []PullRequestRef
+
+	for _, qualifier := range []string{"author", "review-requested", "reviewed-by"} {
+		vars := map[string]interface{}{
+			"query":  githubv4.String(fmt.Sprintf("is:pr is:open archived:false %v:%v", qualifier, user)),
+			"count":  githubv4.Int(prCount),
+			"cursor": (*githubv4.String)(nil),
+		}
+
+		for {
+			var raw struct {
+				Search struct {
+					PageInfo pageInfo
+					Nodes    []struct {
+						PullRequest struct {
+							Number     githubv4.Int
+							Repository struct {
+								NameWithOwner githubv4.String
+							}
+						} `graphql:"... on PullRequest"`
+					}
+				} `graphql:"search(query: $query, type: ISSUE, first: $count, after: $cursor)"`
+			}
+
+			if err := client.cast().Query(ctx, &raw, vars); err != nil {
+				return nil, err
+			}
+
+			for _, node := range raw.Search.Nodes {
+				ref := PullRequestRef{
+					Path:   string(node.PullRequest.Repository.NameWithOwner),
+					Number: int(node.PullRequest.Number),
+				}
+
+				if key := fmt.Sprintf("%v#%v", ref.Path, ref.Number); !known.Test(key) {
+					known.Put(key)
+					refs = append(refs, ref)
+				}
+			}
+
+			page := raw.Search.PageInfo
+			if !page.HasNextPage {
+				break
+			}
+			vars["cursor"] = githubv4.NewString(page.EndCursor)
+		}
+	}
+
+	return refs, nil
+}
+
+func (client *GithubClient) newPullRequest(ctx context.Context, raw rawPullRequest) (*PullRequest, error) {
+	pullRequest := &PullRequest{
+		id:      string(raw.Id),
+		Number:  int32(raw.Number),
+		Title:   string(raw.Title),
+		Author:  string(raw.Author.Login),
+		Age:     NewAge(raw.CreatedAt.Time),
+		Created: raw.CreatedAt.Time,
+		Draft:   bool(raw.IsDraft),
+	}
 
-		pullRequest := &PullRequest{
-			id:     string(rawPullRequest.Id),
-			Number: int32(rawPullRequest.Number),
-			Title:  string(rawPullRequest.Title),
-			Author: string(rawPullRequest.Author.Login),
-			Age:    NewAge(rawPullRequest.CreatedAt.Time),
+	labels := raw.Labels.Nodes
+	for page := raw.Labels.PageInfo; page.HasNextPage; {
+		var more queryPRLabels
+		vars := pageVariables(pullRequest.id, labelCount, page.EndCursor)
+		if err := client.cast().Query(ctx, &more, vars); err != nil {
+			return nil, fmt.Errorf("unable to query labels of PR %v: %v", pullRequest.Number, err)
 		}
 
-		if count := rawPullRequest.Labels.TotalCount; count > labelCount {
-			Warning("Truncated label result for %v/%v PR %v (%v > %v)",
-				vars.Owner, vars.Repository, pullRequest.Number, count, prCount)
+		labels = append(labels, more.Node.PullRequest.Labels.Nodes...)
+		page = more.Node.PullRequest.Labels.PageInfo
+	}
+
+	pullRequest.Labels = NewSet()
+	for _, rawLabel := range labels {
+		pullRequest.Labels.Put(string(rawLabel.Name))
+	}
+
+	reviews := raw.Reviews.Nodes
+	for page := raw.Reviews.PageInfo; page.HasNextPage; {
+		var more queryPRReviews
+		vars := pageVariables(pullRequest.id, reviewCount, page.EndCursor)
+		if err := client.cast().Query(ctx, &more, vars); err != nil {
+			return nil, fmt.Errorf("unable to query reviews of PR %v: %v", pullRequest.Number, err)
+		}
+
+		reviews = append(reviews, more.Node.PullRequest.Reviews.Nodes...)
+		page = more.Node.PullRequest.Reviews.PageInfo
+	}
+
+	for _, rawReview := range reviews {
+		review := Review{
+			Author: string(rawReview.Author.Login),
+			State:  string(rawReview.State),
+			Time:   rawReview.SubmittedAt.Time,
+			Commit: string(rawReview.Commit.Oid),
 		}
 
-		pullRequest.Labels = NewSet()
-		for _, rawLabels := range rawPullRequest.Labels.Nodes {
-			pullRequest.Labels.Put(string(rawLabels.Name))
+		pullRequest.Reviews = append(pullRequest.Reviews, review)
+	}
+
+	sort.Sort(pullRequest.Reviews)
+
+	requests := raw.ReviewRequests.Nodes
+	for page := raw.ReviewRequests.PageInfo; page.HasNextPage; {
+		var more queryPRReviewRequests
+		vars := pageVariables(pullRequest.id, reviewReqCount, page.EndCursor)
+		if err := client.cast().Query(ctx, &more, vars); err != nil {
+			return nil, fmt.Errorf("unable to query review requests of PR %v: %v", pullRequest.Number, err)
 		}
 
-		if count := rawPullRequest.Reviews.TotalCount; count > reviewCount {
-			Warning("Truncated reviews result for %v/%v PR %v (%v > %v)",
-				vars.Owner, vars.Repository, pullRequest.Number, count, prCount)
+		requests = append(requests, more.Node.PullRequest.ReviewRequests.Nodes...)
+		page = more.Node.PullRequest.ReviewRequests.PageInfo
+	}
+
+	pullRequest.ReviewRequests = NewSet()
+	pullRequest.ReviewTeams = NewSet()
+	pullRequest.TeamReviewRequests = NewSet()
+	for _, reviewRequests := range requests {
+		reviewer := reviewRequests.RequestedReviewer
+
+		if user := string(reviewer.User.Login); user != "" {
+			pullRequest.ReviewRequests.Put(user)
 		}
 
-		for _, rawReview := range rawPullRequest.Reviews.Nodes {
-			review := Review{
-				Author: string(rawReview.Author.Login),
-				State:  string(rawReview.State),
-				Time:   rawReview.SubmittedAt.Time,
+		if team := string(reviewer.Team.CombinedSlug); team != "" {
+			pullRequest.ReviewTeams.Put(team)
+
+			members, err := client.TeamMembers(ctx, team)
+			if err != nil {
+				Warning("unable to get members of team '%v' requested on PR %v: %v", team, pullRequest.Number, err)
+				continue
 			}
+			pullRequest.TeamReviewRequests.Add(members)
+		}
+	}
 
-			pullRequest.Reviews = append(pullRequest.Reviews, review)
+	files := raw.Files.Nodes
+	for page := raw.Files.PageInfo; page.HasNextPage; {
+		var more queryPRFiles
+		vars := pageVariables(pullRequest.id, fileCount, page.EndCursor)
+		if err := client.cast().Query(ctx, &more, vars); err != nil {
+			return nil, fmt.Errorf("unable to query files of PR %v: %v", pullRequest.Number, err)
 		}
 
-		sort.Sort(pullRequest.Reviews)
+		files = append(files, more.Node.PullRequest.Files.Nodes...)
+		page = more.Node.PullRequest.Files.PageInfo
+	}
+
+	for _, rawFile := range files {
+		pullRequest.Files = append(pullRequest.Files, string(rawFile.Path))
+	}
 
-		if count := rawPullRequest.ReviewRequests.TotalCount; count > reviewReqCount {
-			Warning("Truncated review request result for %v/%v PR %v (%v > %v)",
-				vars.Owner, vars.Repository, pullRequest.Number, count, prCount)
+	items := raw.TimelineItems.Nodes
+	for page := raw.TimelineItems.PageInfo; page.HasNextPage; {
+		var more queryPRTimelineItems
+		vars := pageVariables(pullRequest.id, timelineCount, page.EndCursor)
+		if err := client.cast().Query(ctx, &more, vars); err != nil {
+			return nil, fmt.Errorf("unable to query timeline of PR %v: %v", pullRequest.Number, err)
 		}
 
-		pullRequest.ReviewRequests = NewSet()
-		for _, reviewRequests := range rawPullRequest.ReviewRequests.Nodes {
-			pullRequest.ReviewRequests.Put(string(reviewRequests.RequestedReviewer.User.Login))
+		items = append(items, more.Node.PullRequest.TimelineItems.Nodes...)
+		page = more.Node.PullRequest.TimelineItems.PageInfo
+	}
+
+	commits := raw.Commits.Nodes
+	for page := raw.Commits.PageInfo; page.HasNextPage; {
+		var more queryPRCommits
+		vars := pageVariables(pullRequest.id, commitCount, page.EndCursor)
+		if err := client.cast().Query(ctx, &more, vars); err != nil {
+			return nil, fmt.Errorf("unable to query commits of PR %v: %v", pullRequest.Number, err)
 		}
 
-		pullRequests = append(pullRequests, pullRequest)
+		commits = append(commits, more.Node.PullRequest.Commits.Nodes...)
+		page = more.Node.PullRequest.Commits.PageInfo
 	}
 
-	if false { // DEBUG
-		bytes, _ := json.MarshalIndent(pullRequests, "", "    ")
-		Debug("PullRequests: %v", string(bytes))
+	pullRequest.Base = string(raw.BaseRef.Name)
+	protection := raw.BaseRef.BranchProtectionRule
+	if protection.RequiresApprovingReviews {
+		pullRequest.RequiredApprovals = int(protection.RequiredApprovingReviewCount)
+		pullRequest.RequiresCodeOwners = bool(protection.RequiresCodeOwnerReviews)
+	}
+
+	pullRequest.Mergeable = string(raw.Mergeable)
+	pullRequest.ReviewDecision = string(raw.ReviewDecision)
+	for _, node := range raw.HeadCommit.Nodes {
+		pullRequest.Checks = string(node.Commit.StatusCheckRollup.State)
+		pullRequest.HeadAt = node.Commit.CommittedDate.Time
+	}
+
+	pullRequest.Head = string(raw.HeadRefOid)
+	for _, node := range commits {
+		pullRequest.Commits = append(pullRequest.Commits, Commit{
+			Oid:   string(node.Commit.Oid),
+			Merge: node.Commit.Parents.TotalCount > 1,
+		})
+	}
+
+	pullRequest.RequestedAt = make(map[string]time.Time)
+	for _, item := range items {
+		event := item.ReviewRequestedEvent
+		user := string(event.RequestedReviewer.User.Login)
+		if user == "" {
+			continue
+		}
+
+		if ts, ok := pullRequest.RequestedAt[user]; !ok || event.CreatedAt.After(ts) {
+			pullRequest.RequestedAt[user] = event.CreatedAt.Time
+		}
 	}
 
-	return pullRequests
+	return pullRequest, nil
 }
 
 var userId map[string]githubv4.ID = make(map[string]githubv4.ID)
+var userIdLock sync.Mutex
 
 func (client GithubClient) userId(ctx context.Context, user string) (githubv4.ID, error) {
-	if id, ok := userId[user]; ok {
+	userIdLock.Lock()
+	id, ok := userId[user]
+	userIdLock.Unlock()
+
+	if ok {
 		return id, nil
 	}
 
@@ -260,28 +838,259 @@ func (client GithubClient) userId(ctx context.Context, user string) (githubv4.ID
 		return nil, err
 	}
 
-	id := raw.User.Id
-	userId[user] = id
-	return id, nil
+	userIdLock.Lock()
+	userId[user] = raw.User.Id
+	userIdLock.Unlock()
+
+	return raw.User.Id, nil
 }
 
-func (client GithubClient) RequestReview(
-	ctx context.Context, pr *PullRequest, users []string, dryRun bool) {
+// QueryCodeOwners returns the CODEOWNERS file of the given branch of the repo
+// or of the default branch if the branch is empty.
+func (client GithubClient) QueryCodeOwners(ctx context.Context, vars Variables, branch string) (CodeOwners, error) {
+	ref := branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	for _, path := range CodeOwnersPaths {
+		var raw struct {
+			Repository struct {
+				Object struct {
+					Blob struct {
+						Text githubv4.String
+					} `graphql:"... on Blob"`
+				} `graphql:"object(expression: $expression)"`
+			} `graphql:"repository(owner: $owner, name: $repo)"`
+		}
+
+		variables := map[string]interface{}{
+			"owner":      githubv4.String(vars.Owner),
+			"repo":       githubv4.String(vars.Repository),
+			"expression": githubv4.String(ref + ":" + path),
+		}
+
+		if err := client.cast().Query(ctx, &raw, variables); err != nil {
+			return nil, fmt.Errorf("unable to query CODEOWNERS of %v/%v@%v: %v", vars.Owner, vars.Repository, ref, err)
+		}
 
-	if len(users) == 0 {
-		return
+		if text := string(raw.Repository.Object.Blob.Text); text != "" {
+			return ParseCodeOwners(text), nil
+		}
 	}
 
+	Warning("no CODEOWNERS file found for %v/%v@%v", vars.Owner, vars.Repository, ref)
+	return nil, nil
+}
+
+// ResolveOwners translates CODEOWNERS entries into Github users by expanding
+// '@org/team' entries into their members. Email entries are ignored as are teams
+// that can't be resolved (e.g. renamed or inaccessible teams).
+func (client GithubClient) ResolveOwners(ctx context.Context, owners Set) Set {
+	result := NewSet()
+
+	for owner, _ := range owners {
+		if !strings.HasPrefix(owner, "@") {
+			continue
+		}
+
+		owner = strings.TrimPrefix(owner, "@")
+		if !strings.Contains(owner, "/") {
+			result.Put(owner)
+			continue
+		}
+
+		team, err := client.team(ctx, owner)
+		if err != nil {
+			Warning("skipping CODEOWNERS team '@%v': %v", owner, err)
+			continue
+		}
+		result.Add(team.Members)
+	}
+
+	return result
+}
+
+type Repository struct {
+	Path     string
+	Topics   Set
+	Archived bool
+}
+
+const (
+	repoCount  = 100
+	topicCount = 20
+)
+
+func (client GithubClient) QueryRepositories(ctx context.Context, org string) []Repository {
+	vars := map[string]interface{}{
+		"org":        githubv4.String(org),
+		"count":      githubv4.Int(repoCount),
+		"topicCount": githubv4.Int(topicCount),
+		"cursor":     (*githubv4.String)(nil),
+	}
+
+	var repos []Repository
+	for {
+		var raw struct {
+			Organization struct {
+				Repositories struct {
+					PageInfo pageInfo
+					Nodes    []struct {
+						NameWithOwner    githubv4.String
+						IsArchived       githubv4.Boolean
+						RepositoryTopics struct {
+							Nodes []struct {
+								Topic struct {
+									Name githubv4.String
+								}
+							}
+						} `graphql:"repositoryTopics(first: $topicCount)"`
+					}
+				} `graphql:"repositories(first: $count, after: $cursor)"`
+			} `graphql:"organization(login: $org)"`
+		}
+
+		if err := client.cast().Query(ctx, &raw, vars); err != nil {
+			Fatal("unable to query repositories of '%v': %v", org, err)
+		}
+
+		for _, node := range raw.Organization.Repositories.Nodes {
+			repo := Repository{
+				Path:     string(node.NameWithOwner),
+				Topics:   NewSet(),
+				Archived: bool(node.IsArchived),
+			}
+
+			for _, topic := range node.RepositoryTopics.Nodes {
+				repo.Topics.Put(string(topic.Topic.Name))
+			}
+
+			repos = append(repos, repo)
+		}
+
+		page := raw.Organization.Repositories.PageInfo
+		if !page.HasNextPage {
+			break
+		}
+		vars["cursor"] = githubv4.NewString(page.EndCursor)
+	}
+
+	return repos
+}
+
+type Team struct {
+	Id      githubv4.ID
+	Members Set
+}
+
+var teamCache map[string]*Team = make(map[string]*Team)
+var teamCacheLock sync.Mutex
+
+// ClearTeamCache forgets the teams queried so far such that changes to their
+// membership are picked up.
+func ClearTeamCache() {
+	teamCacheLock.Lock()
+	defer teamCacheLock.Unlock()
+
+	teamCache = make(map[string]*Team)
+}
+
+func (client GithubClient) team(ctx context.Context, team string) (*Team, error) {
+	teamCacheLock.Lock()
+	result, ok := teamCache[team]
+	teamCacheLock.Unlock()
+
+	if ok {
+		return result, nil
+	}
+
+	split := strings.Split(team, "/")
+	if len(split) != 2 {
+		return nil, fmt.Errorf("invalid team '%v'", team)
+	}
+
+	vars := map[string]interface{}{
+		"org":    githubv4.String(split[0]),
+		"slug":   githubv4.String(split[1]),
+		"count":  githubv4.Int(teamMemberCount),
+		"cursor": (*githubv4.String)(nil),
+	}
+
+	result = &Team{Members: NewSet()}
+	for {
+		var raw struct {
+			Organization struct {
+				Team struct {
+					Id      githubv4.ID
+					Members struct {
+						PageInfo pageInfo
+						Nodes    []struct {
+							Login githubv4.String
+						}
+					} `graphql:"members(first: $count, after: $cursor)"`
+				} `graphql:"team(slug: $slug)"`
+			} `graphql:"organization(login: $org)"`
+		}
+
+		if err := client.cast().Query(ctx, &raw, vars); err != nil {
+			return nil, err
+		}
+
+		result.Id = raw.Organization.Team.Id
+		for _, member := range raw.Organization.Team.Members.Nodes {
+			result.Members.Put(string(member.Login))
+		}
+
+		page := raw.Organization.Team.Members.PageInfo
+		if !page.HasNextPage {
+			break
+		}
+		vars["cursor"] = githubv4.NewString(page.EndCursor)
+	}
+
+	if result.Id == nil {
+		return nil, fmt.Errorf("unknown team '%v'", team)
+	}
+
+	teamCacheLock.Lock()
+	teamCache[team] = result
+	teamCacheLock.Unlock()
+
+	return result, nil
+}
+
+func (client GithubClient) TeamMembers(ctx context.Context, team string) (Set, error) {
+	result, err := client.team(ctx, team)
+	if err != nil {
+		return nil, err
+	}
+	return result.Members, nil
+}
+
+func (client GithubClient) RequestReview(
+	ctx context.Context, pr *PullRequest, users, teams []string, dryRun bool) error {
+
 	var ids []githubv4.ID
 	for _, user := range users {
 		id, err := client.userId(ctx, user)
 		if err != nil {
-			Fatal("unable to translate user '%v' to github id: %v", user, err)
+			return fmt.Errorf("unable to translate user '%v' to github id: %v", user, err)
 		}
 
 		ids = append(ids, id)
 	}
 
+	var teamIds []githubv4.ID
+	for _, team := range teams {
+		result, err := client.team(ctx, team)
+		if err != nil {
+			return fmt.Errorf("unable to translate team '%v' to github id: %v", team, err)
+		}
+
+		teamIds = append(teamIds, result.Id)
+	}
+
 	var raw struct {
 		RequestReviews struct {
 			ClientMutationId githubv4.String
@@ -291,14 +1100,17 @@ func (client GithubClient) RequestReview(
 	input := githubv4.RequestReviewsInput{
 		PullRequestID: githubv4.ID(pr.id),
 		UserIDs:       &ids,
+		TeamIDs:       &teamIds,
 	}
 
 	if dryRun {
-		return
+		return nil
 	}
 
 	if err := client.cast().Mutate(ctx, &raw, input, nil); err != nil {
-		Fatal("unable to request reviews for '%v -> %v' on PR '%v': %v",
-			users, ids, pr.Number, err)
+		return fmt.Errorf("unable to request reviews for '%v -> %v' and '%v -> %v' on PR '%v': %v",
+			users, ids, teams, teamIds, pr.Number, err)
 	}
+
+	return nil
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchGlob matches a slash separated path against a glob pattern where '*',
+// '?' and '[...]' follow the semantics of path.Match within a single path
+// segment and '**' matches any number of segments. A pattern ending with a '/'
+// matches everything under that directory.
+func MatchGlob(pattern, file string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	return matchSegments(
+		strings.Split(strings.TrimPrefix(pattern, "/"), "/"),
+		strings.Split(strings.TrimPrefix(file, "/"), "/"))
+}
+
+func matchSegments(pattern, file []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(file); i++ {
+				if matchSegments(pattern[1:], file[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(file) == 0 {
+			return false
+		}
+
+		if ok, err := path.Match(pattern[0], file[0]); err != nil || !ok {
+			return false
+		}
+
+		pattern = pattern[1:]
+		file = file[1:]
+	}
+
+	return len(file) == 0
+}
+
+func ValidGlob(pattern string) bool {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"log"
 	"math/rand"
@@ -13,6 +12,8 @@ import (
 var full = flag.Bool("full", false, "notify with full summary")
 var dumpUsers = flag.Bool("dump-users", false, "dumps the slack users and exits")
 var dryRun = flag.Bool("dry-run", false, "print slack notifications without sending them")
+var seed = flag.Int64("seed", 0, "seed used to pick reviewers; random if 0")
+var since = flag.Duration("since", 24*time.Hour, "time since the previous run when no state store is persistent")
 
 func main() {
 	flag.Parse()
@@ -44,73 +45,30 @@ func main() {
 	path := os.Getenv("CONFIG")
 	config := ReadConfig(path)
 
-	rand.Seed(time.Now().UnixNano())
+	if *seed != 0 {
+		rand.Seed(*seed)
+	} else {
+		rand.Seed(time.Now().UnixNano())
+	}
 
 	githubClient := ConnectGithub()
 	if err != nil {
 		Fatal("unable to connect to slack: %v", err)
 	}
 
-	ruleset := NewRuleset(config)
-
-	notifs := make(UserNotifications)
-	slackUsers := SlackMapUsers(slackClient, config)
-
-	for index, repo := range config.Repos {
-		Info("[%v/%v] processing %v...", index+1, len(config.Repos), repo.Path)
-
-		vars := PathToVariables(repo.Path)
-		for _, pr := range githubClient.QueryPullRequests(context.TODO(), vars) {
-			result := ruleset.Apply(repo.Rule, pr)
-
-			if !result.New.Empty() {
-				Info("<%v> review request: %v", pr.Number, result.New)
-				requests := pr.ReviewRequests.Union(result.New).ToArray()
-				githubClient.RequestReview(context.TODO(), pr, requests, *dryRun)
-			}
-
-			for user, _ := range result.New {
-				notifs.Add(CategoryAssigned, user, repo.Path, pr)
-			}
-
-			if *full {
-				if result.Ready {
-					if ruleset.KnownUser(pr.Author) {
-						notifs.Add(CategoryReady, pr.Author, repo.Path, pr)
-					}
-				} else {
-					if ruleset.KnownUser(pr.Author) {
-						notifs.Add(CategoryOpen, pr.Author, repo.Path, pr)
-					}
-					for user, _ := range result.Pending.Difference(result.Assigned) {
-						notifs.Add(CategoryPending, user, repo.Path, pr)
-					}
-				}
-				for user, _ := range result.Requested {
-					notifs.Add(CategoryRequested, user, repo.Path, pr)
-				}
-			}
-		}
-	}
-
-	index := 0
-	for githubUser, notif := range notifs {
-		Info("[%v/%v] notifying %v...", index+1, len(notifs), githubUser)
-
-		if slackUser, ok := slackUsers[githubUser]; ok {
-			if err := NotifySlack(slackClient, slackUser, notif, *dryRun); err != nil {
-				Fatal("Unable to notify slack: %v", err)
-			}
-		} else {
-			Warning("unconfigured github user '%v'", githubUser)
-		}
+	engine := NewEngine(config, githubClient, slackClient, *dryRun)
+	engine.SetSince(time.Now().Add(-*since))
 
-		index++
+	if flag.Arg(0) == "serve" {
+		Serve(engine)
+		return
 	}
 
-	stats(notifs)
+	engine.Run(*full)
 }
 
+const StatsDays = 30
+
 type Stat struct {
 	key string
 	val int
@@ -131,7 +89,7 @@ func NewStats(m map[string]int) Stats {
 	return stats
 }
 
-func stats(notifs UserNotifications) {
+func stats(notifs UserNotifications, store Store) {
 	perRepo := make(map[string]int)
 	perUser := make(map[string]int)
 
@@ -155,6 +113,21 @@ func stats(notifs UserNotifications) {
 	for _, stat := range NewStats(perUser) {
 		Info("  %2d %v", stat.val, stat.key)
 	}
+
+	assignments := store.Assignments(time.Now().AddDate(0, 0, -StatsDays))
+	if len(assignments) == 0 {
+		return
+	}
+
+	perUser = make(map[string]int)
+	for _, assignment := range assignments {
+		perUser[assignment.User] += 1
+	}
+
+	Info("reviews assigned per user over the last %v days:", StatsDays)
+	for _, stat := range NewStats(perUser) {
+		Info("  %2d %v", stat.val, stat.key)
+	}
 }
 
 func Fatal(fmt string, args ...interface{}) {
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const EntryActionId = "gups-entry"
+
+type EntryAction string
+
+const (
+	ActionSnooze   EntryAction = "snooze"
+	ActionReassign EntryAction = "reassign"
+	ActionReviewed EntryAction = "reviewed"
+)
+
+var EntryActions = []EntryAction{ActionSnooze, ActionReassign, ActionReviewed}
+
+const SnoozeDuration = 24 * time.Hour
+
+func (action EntryAction) String() string {
+	switch action {
+	case ActionSnooze:
+		return "Snooze 1 day"
+	case ActionReassign:
+		return "I can't review - reassign"
+	case ActionReviewed:
+		return "Mark reviewed"
+	}
+	Fatal("unknown entry action '%v'", string(action))
+	return "meep"
+}
+
+// FormatEntryAction encodes an action on a PR as the value of a Slack action
+// which takes the form `<action>:<org>/<repo>#<number>`.
+func FormatEntryAction(action EntryAction, path string, number int) string {
+	return fmt.Sprintf("%v:%v#%v", string(action), path, number)
+}
+
+func ParseEntryAction(value string) (EntryAction, string, int, error) {
+	split := strings.SplitN(value, ":", 2)
+	if len(split) != 2 {
+		return "", "", 0, fmt.Errorf("malformed action '%v'", value)
+	}
+
+	action := EntryAction(split[0])
+	switch action {
+	case ActionSnooze, ActionReassign, ActionReviewed:
+	default:
+		return "", "", 0, fmt.Errorf("unknown action '%v'", split[0])
+	}
+
+	index := strings.LastIndex(split[1], "#")
+	if index < 0 {
+		return "", "", 0, fmt.Errorf("malformed PR '%v'", split[1])
+	}
+
+	number, err := strconv.Atoi(split[1][index+1:])
+	if err != nil {
+		return "", "", 0, fmt.Errorf("malformed PR number '%v'", split[1][index+1:])
+	}
+
+	return action, split[1][:index], number, nil
+}
+
+// GithubUser returns the Github user associated with the given Slack id.
+func (engine *Engine) GithubUser(slackId string) (string, bool) {
+	engine.slackLock.RLock()
+	defer engine.slackLock.RUnlock()
+
+	for github, id := range engine.slackUsers {
+		if id == slackId {
+			return github, true
+		}
+	}
+	return "", false
+}
+
+// verifySlack reads the body of the request and checks its Slack signature.
+func verifySlack(w http.ResponseWriter, r *http.Request, secret string) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	verifier, err := slack.NewSecretsVerifier(r.Header, secret)
+	if err != nil {
+		http.Error(w, "missing signature", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, "unable to read payload", http.StatusBadRequest)
+		return nil, false
+	}
+
+	verifier.Write(body)
+	if err := verifier.Ensure(); err != nil {
+		Warning("invalid slack signature: %v", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return body, true
+}
+
+// SlackActionsHandler returns the handler for the interactive actions attached
+// to the entries of the Slack notifications. Actions are executed in the
+// background and the outcome is reported to the user through an ephemeral
+// message.
+func SlackActionsHandler(engine *Engine, secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := verifySlack(w, r, secret)
+		if !ok {
+			return
+		}
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, "malformed payload", http.StatusBadRequest)
+			return
+		}
+
+		var callback slack.InteractionCallback
+		if err := json.Unmarshal([]byte(form.Get("payload")), &callback); err != nil {
+			http.Error(w, "malformed payload", http.StatusBadRequest)
+			return
+		}
+
+		user, ok := engine.GithubUser(callback.User.ID)
+		if !ok {
+			Warning("slack action from unknown user '%v'", callback.User.Name)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		for _, action := range callback.ActionCallback.BlockActions {
+			if action.ActionID != EntryActionId {
+				continue
+			}
+
+			value := action.SelectedOption.Value
+			if value == "" {
+				value = action.Value
+			}
+
+			kind, path, number, err := ParseEntryAction(value)
+			if err != nil {
+				Warning("invalid slack action: %v", err)
+				continue
+			}
+
+			channel, slackUser := callback.Channel.ID, callback.User.ID
+			engine.Async(func() {
+				msg := engine.EntryAction(user, kind, path, number)
+				_, err := engine.slack.PostEphemeral(channel, slackUser, slack.MsgOptionText(msg, false))
+				if err != nil {
+					Warning("unable to respond to slack action: %v", err)
+				}
+			})
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// EntryAction executes the action of the user on the given PR and returns a
+// message describing the outcome.
+func (engine *Engine) EntryAction(user string, action EntryAction, path string, number int) string {
+	Info("slack action '%v' from %v on %v#%v", string(action), user, path, number)
+
+	repo, ok := engine.Repo(path)
+	if !ok {
+		return fmt.Sprintf("Unknown repo `%v`", path)
+	}
+
+	key := EntryKey{User: user, Path: repo.Path, Number: number}
+
+	switch action {
+
+	case ActionSnooze:
+		engine.Snooze(key, time.Now().Add(SnoozeDuration))
+		return fmt.Sprintf("Snoozed %v#%v for a day", repo.Path, number)
+
+	case ActionReviewed:
+		ok, err := engine.MarkReviewed(key)
+		switch {
+		case err != nil:
+			return fmt.Sprintf("Unable to query %v#%v, please try again later", repo.Path, number)
+		case !ok:
+			return fmt.Sprintf("%v#%v is no longer open", repo.Path, number)
+		}
+		return fmt.Sprintf("Marked %v#%v as reviewed until new commits are pushed", repo.Path, number)
+
+	case ActionReassign:
+		result, err := engine.RunPullRequest(repo, number, NewSet(user))
+		switch {
+		case err != nil:
+			return fmt.Sprintf("Unable to reassign your review request on %v#%v, please try again later",
+				repo.Path, number)
+		case result == nil:
+			return fmt.Sprintf("%v#%v is no longer open", repo.Path, number)
+		case !result.Removed.Test(user):
+			return fmt.Sprintf("Your review request on %v#%v isn't managed by its rules and can't be reassigned",
+				repo.Path, number)
+		case result.New.Empty():
+			return fmt.Sprintf("Your review request on %v#%v was removed but no replacement could be picked",
+				repo.Path, number)
+		}
+		return fmt.Sprintf("Your review request on %v#%v was reassigned to %v",
+			repo.Path, number, userList(result.New))
+	}
+
+	return fmt.Sprintf("Unknown action `%v`", string(action))
+}
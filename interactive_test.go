@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/shurcooL/githubv4"
+)
+
+func TestEntryActionFormat(t *testing.T) {
+	Debug("[ entry action format ]=================================")
+
+	for _, test := range []struct {
+		action EntryAction
+		path   string
+		number int
+	}{
+		{ActionSnooze, "org/repo", 1},
+		{ActionReassign, "org/repo-name", 1234},
+		{ActionReviewed, "Org/Repo.js", 42},
+	} {
+		value := FormatEntryAction(test.action, test.path, test.number)
+
+		action, path, number, err := ParseEntryAction(value)
+		if err != nil {
+			t.Errorf("unable to parse '%v': %v", value, err)
+			continue
+		}
+
+		if action != test.action || path != test.path || number != test.number {
+			t.Errorf("'%v': val=%v:%v#%v exp=%v:%v#%v",
+				value, action, path, number, test.action, test.path, test.number)
+		}
+	}
+
+	for _, value := range []string{"", "snooze", "snooze:org/repo", "meep:org/repo#1",
+		"snooze:org/repo#", "snooze:org/repo#abc", ":org/repo#1"} {
+		if _, _, _, err := ParseEntryAction(value); err == nil {
+			t.Errorf("expected error for '%v'", value)
+		}
+	}
+}
+
+func TestEntryAction(t *testing.T) {
+	Debug("[ entry action ]========================================")
+
+	config := ParseConfig("test", []byte(`
+{
+    "repos": [{ "path": "org/repo", "rule": "r1" }],
+    "github_to_slack_user": { "u1": "s1", "u2": "s2" },
+    "pools": { "p1": [ "u1", "u2" ] },
+    "ruleset": { "r1": [{ "pick": ["p1"] }] }
+}`))
+
+	// state is the state of the PR returned by the Github API where an empty
+	// state fails the query.
+	state := ""
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state == "" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"data": {"repository": {"pullRequest": {"state": "%v"}}}}`, state)
+	}))
+	defer github.Close()
+
+	ephemeral := make(chan string, 1)
+	slackAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		ephemeral <- r.Form.Get("text")
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer slackAPI.Close()
+
+	engine := &Engine{
+		config:     config,
+		github:     (*GithubClient)(githubv4.NewEnterpriseClient(github.URL, github.Client())),
+		slack:      slack.New("token", slack.OptionAPIURL(slackAPI.URL+"/")),
+		slackUsers: SlackUsers{"u1": "S1", "u2": "S2"},
+		store:      NewMemoryStore(0),
+		load:       make(map[string]int),
+	}
+
+	for _, test := range []struct {
+		title  string
+		state  string
+		action EntryAction
+		path   string
+		exp    string
+	}{
+		{"unknown-repo", "OPEN", ActionSnooze, "org/meep", "Unknown repo `org/meep`"},
+		{"snooze", "", ActionSnooze, "org/repo", "Snoozed org/repo#1 for a day"},
+		{"reviewed-error", "", ActionReviewed, "org/repo", "Unable to query org/repo#1, please try again later"},
+		{"reviewed-closed", "CLOSED", ActionReviewed, "org/repo", "org/repo#1 is no longer open"},
+		{"reassign-error", "", ActionReassign, "org/repo",
+			"Unable to reassign your review request on org/repo#1, please try again later"},
+		{"reassign-closed", "MERGED", ActionReassign, "org/repo", "org/repo#1 is no longer open"},
+	} {
+		state = test.state
+		if msg := engine.EntryAction("u2", test.action, test.path, 1); msg != test.exp {
+			t.Errorf("%v: val='%v' exp='%v'", test.title, msg, test.exp)
+		}
+	}
+
+	if _, ok := engine.store.Snoozed(EntryKey{User: "u2", Path: "org/repo", Number: 1}); !ok {
+		t.Errorf("expected snoozed PR")
+	}
+
+	handler := SlackActionsHandler(engine, "secret")
+
+	post := func(secret, slackUser, value string) int {
+		t.Helper()
+
+		callback := map[string]interface{}{
+			"type":    "block_actions",
+			"user":    map[string]string{"id": slackUser, "name": slackUser},
+			"channel": map[string]string{"id": "C1"},
+			"actions": []map[string]string{
+				{"block_id": "b1", "action_id": EntryActionId, "value": value},
+			},
+		}
+		payload, _ := json.Marshal(callback)
+		body := url.Values{"payload": {string(payload)}}.Encode()
+
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		hash := hmac.New(sha256.New, []byte(secret))
+		hash.Write([]byte("v0:" + ts + ":" + body))
+
+		r := httptest.NewRequest(http.MethodPost, "/slack/actions", strings.NewReader(body))
+		r.Header.Set("X-Slack-Request-Timestamp", ts)
+		r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(hash.Sum(nil)))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		engine.Wait()
+		return w.Code
+	}
+
+	if code := post("wrong", "S1", FormatEntryAction(ActionSnooze, "org/repo", 2)); code != http.StatusUnauthorized {
+		t.Errorf("bad-signature: val=%v exp=%v", code, http.StatusUnauthorized)
+	}
+
+	if code := post("secret", "S3", FormatEntryAction(ActionSnooze, "org/repo", 2)); code != http.StatusOK {
+		t.Errorf("unknown-user: val=%v exp=%v", code, http.StatusOK)
+	}
+
+	if code := post("secret", "S1", FormatEntryAction(ActionSnooze, "org/repo", 2)); code != http.StatusOK {
+		t.Errorf("snooze: val=%v exp=%v", code, http.StatusOK)
+	}
+
+	select {
+	case msg := <-ephemeral:
+		if exp := "Snoozed org/repo#2 for a day"; msg != exp {
+			t.Errorf("snooze: val='%v' exp='%v'", msg, exp)
+		}
+	default:
+		t.Errorf("snooze: missing ephemeral message")
+	}
+
+	for _, key := range []EntryKey{
+		{User: "u1", Path: "org/repo", Number: 2},
+		{User: "u2", Path: "org/repo", Number: 2},
+	} {
+		_, ok := engine.store.Snoozed(key)
+		if exp := key.User == "u1"; ok != exp {
+			t.Errorf("snoozed %v: val=%v exp=%v", key, ok, exp)
+		}
+	}
+}
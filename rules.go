@@ -5,15 +5,25 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
+)
+
+type PickStrategy string
+
+const (
+	PickRandom      PickStrategy = "random"
+	PickLeastLoaded PickStrategy = "least-loaded"
+	PickFair        PickStrategy = "fair"
 )
 
 type Pick struct {
-	Pool  string
-	Count int
+	Pool     string
+	Count    int
+	Strategy PickStrategy
 }
 
 func (pick *Pick) String() string {
-	return fmt.Sprintf("%v:%v", pick.Pool, pick.Count)
+	return fmt.Sprintf("%v:%v:%v", pick.Pool, pick.Count, pick.Strategy)
 }
 
 func (pick *Pick) UnmarshalJSON(data []byte) error {
@@ -36,26 +46,123 @@ func (pick *Pick) UnmarshalJSON(data []byte) error {
 		}
 	}
 
+	pick.Strategy = PickRandom
+	if len(items) > 2 {
+		switch strategy := PickStrategy(items[2]); strategy {
+		case PickRandom, PickLeastLoaded, PickFair:
+			pick.Strategy = strategy
+		default:
+			Fatal("malformed pick '%v': unknown strategy '%v'", raw, items[2])
+		}
+	}
+
+	if len(items) > 3 {
+		Fatal("malformed pick '%v': too many fields", raw)
+	}
+
 	return nil
 }
 
 type Rule struct {
-	If   string `json:"if"`
-	Pick []Pick `json:"pick"`
+	If      string   `json:"if"`
+	IfLabel string   `json:"if_label"`
+	IfFiles []string `json:"if_files"`
+	When    string   `json:"when"`
+	Pick    []Pick   `json:"pick"`
 }
 
 func (rule *Rule) HasIf() bool {
 	return rule.If != ""
 }
 
+func (rule *Rule) HasIfLabel() bool {
+	return rule.IfLabel != ""
+}
+
+func (rule *Rule) HasIfFiles() bool {
+	return len(rule.IfFiles) > 0
+}
+
+func (rule *Rule) HasWhen() bool {
+	return rule.When != ""
+}
+
+// Conditions returns a human readable description of the rule's conditions.
+func (rule *Rule) Conditions() string {
+	var conds []string
+
+	if rule.HasIf() {
+		conds = append(conds, fmt.Sprintf("author in %v", rule.If))
+	}
+	if rule.HasIfLabel() {
+		conds = append(conds, fmt.Sprintf("label(%q)", rule.IfLabel))
+	}
+	if rule.HasIfFiles() {
+		conds = append(conds, fmt.Sprintf("files(%q)", strings.Join(rule.IfFiles, `", "`)))
+	}
+	if rule.HasWhen() {
+		conds = append(conds, fmt.Sprintf("(%v)", rule.When))
+	}
+
+	if len(conds) == 0 {
+		return "always"
+	}
+	return strings.Join(conds, " && ")
+}
+
 type Rules []Rule
 
+type Escalation struct {
+	After   int  `json:"after_business_days"`
+	Replace bool `json:"replace"`
+}
+
+type StaleApprovals struct {
+	IgnoreMerges bool `json:"ignore_merges"`
+}
+
+// RulesetConfig is either specified as a list of rules or as an object
+// containing the list of rules along with the ruleset's options.
+type RulesetConfig struct {
+	Rules          Rules           `json:"rules"`
+	Escalation     *Escalation     `json:"escalation"`
+	StaleApprovals *StaleApprovals `json:"stale_approvals"`
+}
+
+func (config *RulesetConfig) UnmarshalJSON(data []byte) error {
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		return json.Unmarshal(data, &config.Rules)
+	}
+
+	type raw RulesetConfig
+	return json.Unmarshal(data, (*raw)(config))
+}
+
+const CodeOwnersPool = "codeowners"
+
 type Ruleset struct {
 	users   Set
 	pools   map[string]Set
-	ruleset map[string]Rules
+	ruleset map[string]RulesetConfig
+
+	// conditions are the compiled `when` conditions indexed like the rules of
+	// each ruleset where rules without a condition are nil. They're kept out of
+	// the config which is shared with the commands.
+	conditions map[string][]Condition
 
 	skipLabels Set
+	drafts     DraftMode
+
+	load    map[string]int
+	history map[string]int
+	caps    ReviewCaps
+
+	unavailable Set
+	busy        Set
+	reassign    bool
+	declined    Set
+
+	now time.Time
 }
 
 func NewRuleset(config *Config) *Ruleset {
@@ -63,16 +170,32 @@ func NewRuleset(config *Config) *Ruleset {
 		users:      NewSet(),
 		pools:      make(map[string]Set),
 		ruleset:    config.Ruleset,
+		conditions: make(map[string][]Condition),
 		skipLabels: NewSet(config.SkipLabels...),
+		drafts:     config.DraftPRs,
+		load:       make(map[string]int),
+		history:    make(map[string]int),
+		caps:       config.ReviewCaps,
+
+		unavailable: NewSet(),
+		busy:        NewSet(),
+		declined:    NewSet(),
+		reassign:    config.Availability.Reassign,
+
+		now: time.Now(),
 	}
 
 	for user, _ := range config.Users {
 		ruleset.users.Put(user)
 	}
 
-	pools := NewSet()
+	pools := NewSet(CodeOwnersPool)
 
 	for poolName, pool := range config.Pools {
+		if poolName == CodeOwnersPool {
+			Fatal("pool name '%v' is reserved", CodeOwnersPool)
+		}
+
 		set := NewSet(pool...)
 		if diff := set.Difference(ruleset.users); !diff.Empty() {
 			Fatal("unknown users '%v' in pool '%v'", diff, poolName)
@@ -82,12 +205,38 @@ func NewRuleset(config *Config) *Ruleset {
 		ruleset.pools[poolName] = set
 	}
 
-	for ruleName, rules := range ruleset.ruleset {
-		for _, rule := range rules {
+	for ruleName, config := range ruleset.ruleset {
+		if escalation := config.Escalation; escalation != nil && escalation.After < 1 {
+			Fatal("invalid escalation after_business_days '%v' in rule '%v'",
+				escalation.After, ruleName)
+		}
+
+		rules := config.Rules
+		conditions := make([]Condition, len(rules))
+		ruleset.conditions[ruleName] = conditions
+
+		for index := range rules {
+			rule := rules[index]
+
 			if rule.HasIf() && !pools.Test(rule.If) {
 				Fatal("unknown if pool '%v' in rule '%v'", rule.If, ruleName)
 			}
 
+			for _, pattern := range rule.IfFiles {
+				if !ValidGlob(pattern) {
+					Fatal("malformed if_files pattern '%v' in rule '%v'", pattern, ruleName)
+				}
+			}
+
+			if rule.HasWhen() {
+				cond, err := ParseCondition(rule.When, pools)
+				if err != nil {
+					Fatal("unable to parse condition '%v' of rule '%v'[%v]: %v",
+						rule.When, ruleName, index, err)
+				}
+				conditions[index] = cond
+			}
+
 			for _, pick := range rule.Pick {
 				if !pools.Test(pick.Pool) {
 					Fatal("unknown pool name '%v' in rule '%v' for condition '%v'",
@@ -104,58 +253,406 @@ func (ruleset *Ruleset) KnownUser(user string) bool {
 	return ruleset.users.Test(user)
 }
 
+// Load returns the number of pending review requests per user.
+func (ruleset *Ruleset) Load() map[string]int {
+	return ruleset.load
+}
+
+// SetLoad replaces the pending review request counts with a copy of the given
+// counts which is used to reuse the load of a previous run.
+func (ruleset *Ruleset) SetLoad(load map[string]int) {
+	ruleset.load = make(map[string]int, len(load))
+	for user, count := range load {
+		ruleset.load[user] = count
+	}
+}
+
+// SetHistory sets the number of reviews recently assigned to each user which
+// is used by the fair strategy.
+func (ruleset *Ruleset) SetHistory(history map[string]int) {
+	ruleset.history = history
+}
+
+// AddLoad accounts for the pending review requests of the PR which is used by
+// the least-loaded pick strategy. Should be called for every PR of every repo
+// before any call to Apply.
+func (ruleset *Ruleset) AddLoad(pr *PullRequest) {
+	for user, _ := range pr.ReviewRequests {
+		ruleset.load[user]++
+	}
+}
+
+// cap returns the maximum number of pending reviews for a user picked from the
+// given pool where 0 indicates that there are no limits.
+func (ruleset *Ruleset) cap(user, pool string) int {
+	if cap, ok := ruleset.caps.Users[user]; ok {
+		return cap
+	}
+	if cap, ok := ruleset.caps.Pools[pool]; ok {
+		return cap
+	}
+	return ruleset.caps.Default
+}
+
+func (ruleset *Ruleset) capped(pool string, users Set) Set {
+	result := NewSet()
+	for user, _ := range users {
+		if cap := ruleset.cap(user, pool); cap > 0 && ruleset.load[user] >= cap {
+			result.Put(user)
+		}
+	}
+	return result
+}
+
+// SetUnavailable excludes the given users from being picked and, if configured,
+// reassigns their pending reviews.
+func (ruleset *Ruleset) SetUnavailable(users Set) {
+	ruleset.unavailable = users
+}
+
+// SetBusy excludes the given users from being picked but, unlike
+// SetUnavailable, their pending reviews are never reassigned. Meant for short
+// absences such as a Slack status or do-not-disturb mode.
+func (ruleset *Ruleset) SetBusy(users Set) {
+	ruleset.busy = users
+}
+
+// Unavailable returns the users that are excluded from being picked.
+func (ruleset *Ruleset) Unavailable() Set {
+	return ruleset.unavailable.Union(ruleset.busy)
+}
+
+// Decline removes the pending review requests of the given users and excludes
+// them from being picked. Meant to be used when applying the rules to a single
+// PR.
+func (ruleset *Ruleset) Decline(users Set) {
+	ruleset.declined = users
+}
+
+// deferred indicates whether picking reviewers for the PR is deferred until the
+// PR is marked ready for review.
+func (ruleset *Ruleset) deferred(pr *PullRequest) bool {
+	return pr.Draft && ruleset.drafts == DraftReady
+}
+
+// pickFrom picks up to count users amongst the available candidates that are
+// below their review cap using the strategy of the pick. Capped candidates are
+// added to the result if the pick can't be fulfilled.
+func (ruleset *Ruleset) pickFrom(pr *PullRequest, pick Pick, candidates Set, count int, result *Result) Set {
+	if ruleset.deferred(pr) {
+		return NewSet()
+	}
+
+	candidates = candidates.
+		Difference(ruleset.unavailable).
+		Difference(ruleset.busy).
+		Difference(ruleset.declined)
+	capped := ruleset.capped(pick.Pool, candidates)
+	candidates = candidates.Difference(capped)
+
+	var picked Set
+	switch pick.Strategy {
+	case PickLeastLoaded:
+		picked = candidates.PickLeastLoaded(count, ruleset.load)
+	case PickFair:
+		picked = candidates.PickWeighted(count, func(user string) float64 {
+			return 1 / float64(1+ruleset.history[user])
+		})
+	default:
+		picked = candidates.Pick(count)
+	}
+
+	for user, _ := range picked {
+		ruleset.load[user]++
+		ruleset.history[user]++
+	}
+
+	if len(picked) < count && !capped.Empty() {
+		Warning("<%v> no eligible reviewers left in pool '%v': %v at max pending reviews",
+			pr.Number, pick.Pool, capped)
+		result.Capped.Add(capped)
+	}
+
+	return picked
+}
+
+// topUp assigns up to count additional reviewers from the pool of the pick
+// where reviewers already active on the PR are preferred over picking new ones.
+func (ruleset *Ruleset) topUp(pr *PullRequest, pick Pick, count int, all Set, result *Result) Set {
+	pool := ruleset.pool(pick.Pool, pr).
+		Difference(NewSet(pr.Author)).
+		Difference(result.Assigned).
+		Difference(result.Removed)
+
+	assigned := pool.Intersect(all).Take(count)
+	if missing := count - len(assigned); missing > 0 {
+		picked := ruleset.pickFrom(pr, pick, pool.Difference(all), missing, result)
+		assigned.Add(picked)
+		result.New.Add(picked)
+	}
+
+	result.Assigned.Add(assigned)
+	return assigned
+}
+
+// stale returns the pending reviewers whose review request is older than the
+// escalation delay.
+func (ruleset *Ruleset) stale(escalation *Escalation, pr *PullRequest, pending Set) Set {
+	result := NewSet()
+	if escalation == nil {
+		return result
+	}
+
+	for user, _ := range pending {
+		if ts, ok := pr.RequestedAt[user]; ok && BusinessDays(ts, ruleset.now) >= escalation.After {
+			result.Put(user)
+		}
+	}
+
+	return result
+}
+
+// BusinessDays returns the number of weekdays elapsed between from and to.
+func BusinessDays(from, to time.Time) int {
+	days := 0
+	for ts := from.AddDate(0, 0, 1); !ts.After(to); ts = ts.AddDate(0, 0, 1) {
+		if ts.Weekday() != time.Saturday && ts.Weekday() != time.Sunday {
+			days++
+		}
+	}
+	return days
+}
+
+func (ruleset *Ruleset) UsesCodeOwners(ruleName string) bool {
+	for index, rule := range ruleset.ruleset[ruleName].Rules {
+		if rule.If == CodeOwnersPool {
+			return true
+		}
+
+		if when := ruleset.conditions[ruleName][index]; when != nil && condUsesPool(when, CodeOwnersPool) {
+			return true
+		}
+
+		for _, pick := range rule.Pick {
+			if pick.Pool == CodeOwnersPool {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UsesCommits indicates whether the ruleset requires the commits of the PRs
+// which are only needed to ignore merge commits for stale approvals.
+func (ruleset *Ruleset) UsesCommits(ruleName string) bool {
+	stale := ruleset.ruleset[ruleName].StaleApprovals
+	return stale != nil && stale.IgnoreMerges
+}
+
+func (ruleset *Ruleset) pool(name string, pr *PullRequest) Set {
+	if name == CodeOwnersPool {
+		return ruleset.owners(pr)
+	}
+	return ruleset.pools[name]
+}
+
+// owners returns the configured users that own the files of the PR where
+// owners are matched regardless of case like Github logins.
+func (ruleset *Ruleset) owners(pr *PullRequest) Set {
+	owners := pr.CodeOwners.Fold()
+
+	result := NewSet()
+	for user, _ := range ruleset.users {
+		if owners.Test(strings.ToLower(user)) {
+			result.Put(user)
+		}
+	}
+	return result
+}
+
+// Rule returns the rule of the ruleset at the given index.
+func (ruleset *Ruleset) Rule(ruleName string, index int) Rule {
+	return ruleset.ruleset[ruleName].Rules[index]
+}
+
+func (ruleset *Ruleset) match(rule *Rule, when Condition, pr *PullRequest) bool {
+	if rule.HasIf() && !ruleset.pool(rule.If, pr).Test(pr.Author) {
+		return false
+	}
+
+	if rule.HasIfLabel() && !pr.Labels.Test(rule.IfLabel) {
+		return false
+	}
+
+	if rule.HasIfFiles() && !pr.Touches(rule.IfFiles) {
+		return false
+	}
+
+	if when != nil && !when.Eval(ruleset, pr) {
+		return false
+	}
+
+	return true
+}
+
 type Result struct {
 	New       Set
 	Pending   Set
 	Assigned  Set
 	Requested Set
+	Removed   Set
+	Escalated Set
+	Rereview  Set
 	Ready     bool
+
+	AwaitingAuthor Set
+	Skip           bool
+
+	// EscalatedTo maps the escalated users to the users picked in their stead.
+	EscalatedTo map[string]Set
+
+	// Capped are the users that were skipped because of their review cap while
+	// leaving a pick unfulfilled.
+	Capped Set
+
+	// Rule is the index of the matched rule or -1 if no rules matched.
+	Rule int
 }
 
 func (ruleset *Ruleset) Apply(ruleName string, pr *PullRequest) Result {
 	if !pr.Labels.Intersect(ruleset.skipLabels).Empty() {
-		return Result{}
+		return Result{Rule: -1}
+	}
+
+	if pr.Draft {
+		switch ruleset.drafts {
+		case DraftSkip:
+			return Result{Skip: true, Rule: -1}
+		case DraftAuthor:
+			return Result{Rule: -1}
+		}
 	}
 
 	result := Result{
-		New:      NewSet(),
-		Pending:  NewSet(),
-		Assigned: NewSet(),
+		New:       NewSet(),
+		Pending:   NewSet(),
+		Assigned:  NewSet(),
+		Removed:   NewSet(),
+		Escalated: NewSet(),
+
+		AwaitingAuthor: NewSet(),
+		EscalatedTo:    make(map[string]Set),
+		Capped:         NewSet(),
+		Rule:           -1,
 	}
 
+	config := ruleset.ruleset[ruleName]
+
 	author := NewSet(pr.Author)
 	reviewed := pr.Reviewed()
+	changes, awaiting := pr.ChangesRequested()
+
+	outdated := NewSet()
+	if config.StaleApprovals != nil {
+		outdated = reviewed.Intersect(pr.StaleApprovals(config.StaleApprovals.IgnoreMerges))
+		reviewed = reviewed.Difference(outdated)
+	}
 
-	all := pr.ReviewRequests.Union(reviewed)
+	removable := ruleset.declined
+	if ruleset.reassign {
+		removable = removable.Union(ruleset.unavailable)
+	}
+
+	all := pr.ReviewRequests.Union(reviewed).Union(changes).Union(outdated)
+	all = all.Difference(removable.Difference(reviewed))
 
-	for _, rule := range ruleset.ruleset[ruleName] {
-		if rule.HasIf() && !ruleset.pools[rule.If].Test(pr.Author) {
+	for index, rule := range config.Rules {
+		if !ruleset.match(&rule, ruleset.conditions[ruleName][index], pr) {
 			continue
 		}
+		result.Rule = index
 
 		for _, pick := range rule.Pick {
-			pool := ruleset.pools[pick.Pool]
+			pool := ruleset.pool(pick.Pool, pr)
+
+			away := pool.Intersect(pr.ReviewRequests).Difference(all)
+			for user, _ := range away.Difference(result.Removed) {
+				ruleset.load[user]--
+			}
+			result.Removed.Add(away)
 
 			active := pool.Intersect(all).Difference(author)
-			assigned := active.Copy().Take(pick.Count)
+			stale := ruleset.stale(config.Escalation, pr, active.Difference(reviewed).Difference(awaiting))
+			assigned := active.Difference(stale).Take(pick.Count)
 
+			picked := NewSet()
 			if missing := pick.Count - len(assigned); missing > 0 {
-				picked := pool.Difference(active.Union(author)).Pick(missing)
+				picked = ruleset.pickFrom(pr, pick, pool.Difference(active.Union(author)), missing, &result)
 				assigned.Add(picked)
 				result.New.Add(picked)
 			}
 
-			result.Pending.Add(assigned.Difference(reviewed))
+			if !stale.Empty() && !picked.Empty() {
+				Info("<%v> escalated stale reviews: %v -> %v", pr.Number, stale, picked)
+				result.Escalated.Add(stale)
+
+				for user, _ := range stale {
+					if _, ok := result.EscalatedTo[user]; !ok {
+						result.EscalatedTo[user] = NewSet()
+					}
+					result.EscalatedTo[user].Add(picked)
+				}
+			}
+
+			if !picked.Empty() && config.Escalation != nil &&
+				config.Escalation.Replace && len(picked) >= len(stale) {
+				for user, _ := range stale.Difference(result.Removed) {
+					ruleset.load[user]--
+				}
+				result.Removed.Add(stale)
+			} else {
+				assigned.Add(stale)
+			}
+
+			result.Pending.Add(assigned.Difference(reviewed).Difference(awaiting))
+			result.AwaitingAuthor.Add(assigned.Intersect(awaiting))
 			result.Assigned.Add(assigned)
 		}
 
+		// Top up the assigned reviewers to satisfy the base branch protection.
+		missing := pr.RequiredApprovals - len(result.Assigned)
+		for _, pick := range rule.Pick {
+			if missing <= 0 {
+				break
+			}
+
+			assigned := ruleset.topUp(pr, pick, missing, all, &result)
+			result.Pending.Add(assigned.Difference(reviewed).Difference(awaiting))
+			result.AwaitingAuthor.Add(assigned.Intersect(awaiting))
+			missing -= len(assigned)
+		}
+
+		owners := ruleset.pool(CodeOwnersPool, pr)
+		if pr.RequiresCodeOwners && owners.Intersect(result.Assigned).Empty() {
+			pick := Pick{Pool: CodeOwnersPool, Count: 1, Strategy: PickRandom}
+			assigned := ruleset.topUp(pr, pick, 1, all, &result)
+			result.Pending.Add(assigned.Difference(reviewed).Difference(awaiting))
+			result.AwaitingAuthor.Add(assigned.Intersect(awaiting))
+		}
+
 		break
 	}
 
-	result.Ready = result.Pending.Empty()
+	result.Rereview = result.Assigned.Intersect(outdated).Difference(pr.ReviewRequests)
+	result.Ready = result.Pending.Empty() && result.AwaitingAuthor.Empty() && result.Capped.Empty() &&
+		pr.Protected(reviewed) && !ruleset.deferred(pr)
 	result.Requested = pr.ReviewRequests.
+		Union(pr.TeamReviewRequests).
 		Difference(result.Assigned).
+		Difference(result.Removed).
 		Difference(reviewed).
+		Difference(changes).
+		Difference(outdated).
 		Intersect(ruleset.users)
 
 	return result
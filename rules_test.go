@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestBasics(t *testing.T) {
@@ -123,6 +125,691 @@ func TestRequested(t *testing.T) {
 		New(), Pending("u2"), Assigned("u2"), Requested("u3"), Ready(false))
 }
 
+func TestTeams(t *testing.T) {
+	Debug("[ teams ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": { "p1": [ "u2" ] },
+    "ruleset": {
+        "r1": [{ "pick": ["p1"] }]
+    }`)
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1").RequestTeam("u2", "u3", "u6"),
+		New("u2"), Pending("u2"), Assigned("u2"), Requested("u3"), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr2", "u1").Request("u2").RequestTeam("u2", "u3").Review("u3", true),
+		New(), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+}
+
+func TestDrafts(t *testing.T) {
+	Debug("[ drafts ]==============================================")
+
+	body := `
+    "draft_prs": "%v",
+    "pools": { "p1": [ "u1", "u2" ] },
+    "ruleset": {
+        "r1": [{ "pick": ["p1"] }]
+    }`
+
+	ruleset := MakeRuleset(fmt.Sprintf(body, "assign"))
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1").AsDraft(),
+		New("u2"), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	ruleset = MakeRuleset(fmt.Sprintf(body, "author"))
+
+	Check(t, ruleset, "r1",
+		PR("pr2", "u1").AsDraft(),
+		New(), Pending(), Assigned(), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr3", "u1"),
+		New("u2"), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr4", "u1").AsDraft().Request("u2"),
+		New(), Pending(), Assigned(), Requested(), Ready(false))
+
+	ruleset = MakeRuleset(fmt.Sprintf(body, "ready"))
+
+	Check(t, ruleset, "r1",
+		PR("pr5", "u1").AsDraft(),
+		New(), Pending(), Assigned(), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr6", "u1").AsDraft().Request("u2"),
+		New(), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr7", "u1").AsDraft().Review("u2", true),
+		New(), Pending(), Assigned("u2"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr8", "u1"),
+		New("u2"), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	ruleset = MakeRuleset(fmt.Sprintf(body, "skip"))
+
+	if result := ruleset.Apply("r1", PR("pr9", "u1").AsDraft()); !result.Skip {
+		t.Errorf("pr9-skip: val=%v exp=%v", result.Skip, true)
+	}
+
+	if result := ruleset.Apply("r1", PR("pr10", "u1")); result.Skip {
+		t.Errorf("pr10-skip: val=%v exp=%v", result.Skip, false)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	Debug("[ files ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": {
+        "p1": [ "u1" ],
+        "p2": [ "u2" ],
+        "p3": [ "u3" ]
+    },
+    "ruleset": {
+        "r1": [
+            { "if_files": ["services/billing/**"], "pick": ["p2"] },
+            { "if": "p1", "if_files": ["docs/", "*.md"], "pick": ["p3"] },
+            { "pick": ["p1"] }
+        ]
+    }`)
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u3").Touch("README.md", "services/billing/api/main.go"),
+		New("u2"), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr2", "u1").Touch("docs/index.html"),
+		New("u3"), Pending("u3"), Assigned("u3"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr3", "u2").Touch("docs/index.html"),
+		New("u1"), Pending("u1"), Assigned("u1"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr4", "u1").Touch("services/README.md"),
+		New(), Pending(), Assigned(), Requested(), Ready(true))
+}
+
+func TestCodeOwners(t *testing.T) {
+	Debug("[ codeowners ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": { "p1": [ "u4" ] },
+    "ruleset": {
+        "r1": [{ "pick": ["codeowners:2", "p1"] }],
+        "r2": [{ "if": "codeowners", "pick": ["p1"] }],
+        "r3": [{ "when": "label(\"a\") || !(author in codeowners)", "pick": ["p1"] }],
+        "r4": [{ "if": "p1", "when": "author in p1", "pick": ["p1"] }]
+    }`)
+
+	owners := ParseCodeOwners(`
+# comment
+*               @u1
+*.js            @u2 # trailing comment
+/build/logs/    @u3
+docs/*          @u5 @u6
+`)
+
+	for _, test := range []struct {
+		file   string
+		owners Set
+	}{
+		{"main.go", NewSet("@u1")},
+		{"web/app.js", NewSet("@u2")},
+		{"build/logs/a/b.txt", NewSet("@u3")},
+		{"docs/index.md", NewSet("@u5", "@u6")},
+		{"docs/api/index.md", NewSet("@u1")},
+	} {
+		CheckSet(t, "owners-"+test.file, test.owners, owners.Owners([]string{test.file}))
+	}
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1").Own("u1", "u2", "u3"),
+		New("u2", "u3", "u4"), Pending("u2", "u3", "u4"), Assigned("u2", "u3", "u4"),
+		Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr2", "u1").Own("u5", "u6"),
+		New("u4", "u5"), Pending("u4", "u5"), Assigned("u4", "u5"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr3", "u1").Own("u2", "u3").Request("u3"),
+		New("u2", "u4"), Pending("u2", "u3", "u4"), Assigned("u2", "u3", "u4"),
+		Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr4", "u1").Own("U2", "u3").Request("u4"),
+		New("u2", "u3"), Pending("u2", "u3", "u4"), Assigned("u2", "u3", "u4"),
+		Requested(), Ready(false))
+
+	if pr := PR("pr5", "u1").Protect(1, true).Own("U2"); !pr.Protected(NewSet("u2")) {
+		t.Errorf("pr5-protected: val=%v exp=%v", false, true)
+	}
+
+	Check(t, ruleset, "r2",
+		PR("pr6", "u1").Own("u1", "u2"),
+		New("u4"), Pending("u4"), Assigned("u4"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r2",
+		PR("pr7", "u1").Own("u2"),
+		New(), Pending(), Assigned(), Requested(), Ready(true))
+
+	for rule, exp := range map[string]bool{"r1": true, "r2": true, "r3": true, "r4": false} {
+		if val := ruleset.UsesCodeOwners(rule); val != exp {
+			t.Errorf("uses-codeowners-%v: val=%v exp=%v", rule, val, exp)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	Debug("[ labels ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": {
+        "p1": [ "u1" ],
+        "p2": [ "u2" ],
+        "p3": [ "u3" ]
+    },
+    "ruleset": {
+        "r1": [
+            { "if": "p1", "if_label": "security", "pick": ["p2"] },
+            { "if_label": "security", "pick": ["p3"] },
+            { "pick": ["p1"] }
+        ]
+    }`)
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1").Label("security"),
+		New("u2"), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr2", "u2").Label("bug", "security"),
+		New("u3"), Pending("u3"), Assigned("u3"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr3", "u2").Label("bug"),
+		New("u1"), Pending("u1"), Assigned("u1"), Requested(), Ready(false))
+}
+
+func TestWhen(t *testing.T) {
+	Debug("[ when ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": {
+        "p1": [ "u1" ],
+        "p2": [ "u2" ],
+        "p3": [ "u3" ]
+    },
+    "ruleset": {
+        "r1": [
+            { "when": "author in p1 && !label(\"wip\") && files(\"docs/**\")", "pick": ["p2"] },
+            { "when": "(label(\"a\") || label(\"b\")) && !draft", "pick": ["p3"] },
+            { "pick": ["p1"] }
+        ]
+    }`)
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1").Touch("docs/index.md"),
+		New("u2"), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr2", "u1").Touch("docs/index.md").Label("wip"),
+		New(), Pending(), Assigned(), Requested(), Ready(true))
+
+	Check(t, ruleset, "r1",
+		PR("pr3", "u2").Label("b"),
+		New("u3"), Pending("u3"), Assigned("u3"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr4", "u2").Label("b").AsDraft(),
+		New("u1"), Pending("u1"), Assigned("u1"), Requested(), Ready(false))
+
+	pools := NewSet("p1")
+	for _, test := range []struct {
+		expr string
+		err  string
+	}{
+		{`author in p2`, "column 11: unknown pool 'p2'"},
+		{`author p1`, "column 8: expected 'in' but got 'p1'"},
+		{`label("a"`, "column 10: expected ')' but got end of expression"},
+		{`true && `, "column 9: expected a condition but got end of expression"},
+		{`true )`, "column 6: unexpected ')'"},
+		{`label("a) && true`, "column 7: unterminated string"},
+		{`draft # comment`, "column 7: unexpected character '#'"},
+		{`wip`, "column 1: unknown condition 'wip'"},
+	} {
+		_, err := ParseCondition(test.expr, pools)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("parse '%v': val=%v exp=%v", test.expr, err, test.err)
+		}
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	Debug("[ least-loaded ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": { "p1": [ "u2", "u3", "u4", "u5" ] },
+    "ruleset": {
+        "r1": [{ "pick": ["p1:1:least-loaded"] }],
+        "r2": [{ "pick": ["p1:2:least-loaded"] }]
+    }`)
+
+	ruleset.AddLoad(PR("load1", "u1").Request("u2").Request("u3"))
+	ruleset.AddLoad(PR("load2", "u1").Request("u2").Request("u3").Request("u4"))
+	ruleset.AddLoad(PR("load3", "u1").Request("u2"))
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1"),
+		New("u5"), Pending("u5"), Assigned("u5"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r2",
+		PR("pr2", "u1"),
+		New("u4", "u5"), Pending("u4", "u5"), Assigned("u4", "u5"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r2",
+		PR("pr3", "u5").Request("u3"),
+		New("u4"), Pending("u3", "u4"), Assigned("u3", "u4"), Requested(), Ready(false))
+}
+
+func TestFair(t *testing.T) {
+	Debug("[ fair ]====================================================")
+
+	ruleset := MakeRuleset(`
+    "pools": { "p1": [ "u2", "u3", "u4", "u5" ] },
+    "ruleset": {
+        "r1": [{ "pick": ["p1:1:fair"] }],
+        "r2": [{ "pick": ["p1:2:fair"] }]
+    }`)
+	ruleset.SetHistory(map[string]int{"u2": 1000, "u3": 1000, "u5": 1000})
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1"),
+		New("u4"), Pending("u4"), Assigned("u4"), Requested(), Ready(false))
+
+	ruleset.SetHistory(map[string]int{"u2": 1000, "u3": 1000})
+
+	Check(t, ruleset, "r2",
+		PR("pr2", "u1"),
+		New("u4", "u5"), Pending("u4", "u5"), Assigned("u4", "u5"), Requested(), Ready(false))
+
+	for i := 0; i < 2; i++ {
+		ruleset.SetHistory(map[string]int{})
+		Check(t, ruleset, "r2",
+			PR("pr3", "u1"),
+			New("u2", "u5"), Pending("u2", "u5"), Assigned("u2", "u5"), Requested(), Ready(false))
+	}
+}
+
+func TestCaps(t *testing.T) {
+	Debug("[ caps ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "max_pending_reviews": { "default": 2, "pools": { "p2": 3 }, "users": { "u4": 1 } },
+    "pools": {
+        "p1": [ "u2", "u3", "u4" ],
+        "p2": [ "u2", "u3" ]
+    },
+    "ruleset": {
+        "r1": [{ "pick": ["p1:2"] }],
+        "r2": [{ "pick": ["p2:1"] }]
+    }`)
+
+	ruleset.AddLoad(PR("load1", "u1").Request("u2").Request("u4"))
+	ruleset.AddLoad(PR("load2", "u1").Request("u2"))
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1"),
+		New("u3"), Pending("u3"), Assigned("u3"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r2",
+		PR("pr2", "u1").Request("u3"),
+		New(), Pending("u3"), Assigned("u3"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r2",
+		PR("pr3", "u3"),
+		New("u2"), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr4", "u1"),
+		New("u3"), Pending("u3"), Assigned("u3"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr5", "u1"),
+		New(), Pending(), Assigned(), Requested(), Ready(false))
+	CheckSet(t, "pr5-capped", NewSet("u2", "u3", "u4"), ruleset.Apply("r1", PR("pr5", "u1")).Capped)
+}
+
+func TestAvailability(t *testing.T) {
+	Debug("[ availability ]==============================================")
+
+	body := `
+    "availability": { "reassign": %v },
+    "pools": { "p1": [ "u2", "u3", "u4" ] },
+    "ruleset": {
+        "r1": [{ "pick": ["p1:2"] }]
+    }`
+
+	ruleset := MakeRuleset(fmt.Sprintf(body, false))
+	ruleset.SetUnavailable(NewSet("u2", "u3"))
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1"),
+		New("u4"), Pending("u4"), Assigned("u4"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr2", "u1").Request("u2"),
+		New("u4"), Pending("u2", "u4"), Assigned("u2", "u4"), Requested(), Ready(false))
+
+	ruleset = MakeRuleset(fmt.Sprintf(body, true))
+	ruleset.SetUnavailable(NewSet("u2", "u3"))
+
+	pr := PR("pr3", "u1").Request("u2").Request("u3").Review("u3", true)
+	Check(t, ruleset, "r1", pr,
+		New("u4"), Pending("u4"), Assigned("u3", "u4"), Requested(), Ready(false))
+	CheckSet(t, "pr3-removed", NewSet("u2"), ruleset.Apply("r1", pr).Removed)
+
+	ruleset = MakeRuleset(fmt.Sprintf(body, true))
+	ruleset.SetBusy(NewSet("u2", "u3"))
+
+	Check(t, ruleset, "r1",
+		PR("pr4", "u1"),
+		New("u4"), Pending("u4"), Assigned("u4"), Requested(), Ready(false))
+
+	pr = PR("pr5", "u1").Request("u2")
+	Check(t, ruleset, "r1", pr,
+		New("u4"), Pending("u2", "u4"), Assigned("u2", "u4"), Requested(), Ready(false))
+	CheckSet(t, "pr5-removed", NewSet(), ruleset.Apply("r1", pr).Removed)
+}
+
+func TestEscalation(t *testing.T) {
+	Debug("[ escalation ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": { "p1": [ "u2", "u3" ], "p2": [ "u4" ] },
+    "ruleset": {
+        "r1": {
+            "escalation": { "after_business_days": 2 },
+            "rules": [{ "pick": ["p1"] }]
+        },
+        "r2": {
+            "escalation": { "after_business_days": 2, "replace": true },
+            "rules": [{ "pick": ["p1"] }]
+        },
+        "r3": {
+            "escalation": { "after_business_days": 2 },
+            "rules": [{ "pick": ["p1", "p2"] }]
+        }
+    }`)
+
+	// Monday
+	ruleset.now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	friday := ruleset.now.AddDate(0, 0, -3)
+	thursday := ruleset.now.AddDate(0, 0, -4)
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1").RequestAt("u2", friday),
+		New(), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	pr := PR("pr2", "u1").RequestAt("u2", thursday)
+	Check(t, ruleset, "r1", pr,
+		New("u3"), Pending("u2", "u3"), Assigned("u2", "u3"), Requested(), Ready(false))
+	CheckSet(t, "pr2-escalated", NewSet("u2"), ruleset.Apply("r1", pr).Escalated)
+
+	pr = PR("pr3", "u1").RequestAt("u2", thursday).Request("u3")
+	Check(t, ruleset, "r1", pr,
+		New(), Pending("u2", "u3"), Assigned("u2", "u3"), Requested(), Ready(false))
+	CheckSet(t, "pr3-escalated", NewSet(), ruleset.Apply("r1", pr).Escalated)
+
+	pr = PR("pr4", "u1").RequestAt("u2", thursday)
+	Check(t, ruleset, "r2", pr,
+		New("u3"), Pending("u3"), Assigned("u3"), Requested(), Ready(false))
+	CheckSet(t, "pr4-removed", NewSet("u2"), ruleset.Apply("r2", pr).Removed)
+
+	Check(t, ruleset, "r2",
+		PR("pr5", "u3").RequestAt("u2", thursday),
+		New(), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	pr = PR("pr6", "u1").RequestAt("u2", thursday)
+	Check(t, ruleset, "r3", pr,
+		New("u3", "u4"), Pending("u2", "u3", "u4"), Assigned("u2", "u3", "u4"), Requested(), Ready(false))
+	CheckSet(t, "pr6-escalated-to", NewSet("u3"), ruleset.Apply("r3", pr).EscalatedTo["u2"])
+}
+
+func TestReviewStates(t *testing.T) {
+	Debug("[ review states ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": { "p1": [ "u2", "u3" ] },
+    "ruleset": {
+        "r1": [{ "pick": ["p1"] }]
+    }`)
+
+	review := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	after := review.Add(time.Hour)
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1").ReviewAs("u2", ReviewCommented, after).ReviewAs("u2", ReviewApproved, review),
+		New(), Pending(), Assigned("u2"), Requested(), Ready(true))
+
+	pr := PR("pr2", "u1").Commit("c1", false).ReviewOn("u2", ReviewChangesRequested, "c1")
+	Check(t, ruleset, "r1", pr,
+		New(), Pending(), Assigned("u2"), Requested(), Ready(false))
+	CheckSet(t, "pr2-awaiting", NewSet("u2"), ruleset.Apply("r1", pr).AwaitingAuthor)
+
+	Check(t, ruleset, "r1",
+		PR("pr3", "u1").
+			Commit("c1", false).
+			ReviewAs("u2", ReviewCommented, after).
+			ReviewOn("u2", ReviewChangesRequested, "c1"),
+		New(), Pending(), Assigned("u2"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr4", "u1").Commit("c1", false).ReviewOn("u2", ReviewChangesRequested, "c1").Commit("c2", false),
+		New(), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr5", "u1").ReviewAs("u2", ReviewChangesRequested, review).Request("u2"),
+		New(), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr6", "u1").Request("u2").ReviewAs("u3", ReviewChangesRequested, review),
+		New(), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+}
+
+func TestStaleApprovals(t *testing.T) {
+	Debug("[ stale approvals ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": { "p1": [ "u2" ] },
+    "ruleset": {
+        "r1": {
+            "stale_approvals": {},
+            "rules": [{ "pick": ["p1"] }]
+        },
+        "r2": {
+            "stale_approvals": { "ignore_merges": true },
+            "rules": [{ "pick": ["p1"] }]
+        },
+        "r3": [{ "pick": ["p1"] }]
+    }`)
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1").Commit("c1", false).ApproveAt("u2", "c1"),
+		New(), Pending(), Assigned("u2"), Requested(), Ready(true))
+
+	pr := PR("pr2", "u1").Commit("c1", false).Commit("c2", false).ApproveAt("u2", "c1")
+	Check(t, ruleset, "r1", pr,
+		New(), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+	CheckSet(t, "pr2-rereview", NewSet("u2"), ruleset.Apply("r1", pr).Rereview)
+
+	Check(t, ruleset, "r3", pr,
+		New(), Pending(), Assigned("u2"), Requested(), Ready(true))
+
+	pr = PR("pr3", "u1").Commit("c1", false).Commit("c2", false).ApproveAt("u2", "c1").Request("u2")
+	CheckSet(t, "pr3-rereview", NewSet(), ruleset.Apply("r1", pr).Rereview)
+
+	pr = PR("pr4", "u1").Commit("c1", false).Commit("c2", true).ApproveAt("u2", "c1")
+	Check(t, ruleset, "r1", pr,
+		New(), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+	Check(t, ruleset, "r2", pr,
+		New(), Pending(), Assigned("u2"), Requested(), Ready(true))
+
+	pr = PR("pr5", "u1").Commit("c1", false).Commit("c2", true).Commit("c3", false).ApproveAt("u2", "c1")
+	Check(t, ruleset, "r2", pr,
+		New(), Pending("u2"), Assigned("u2"), Requested(), Ready(false))
+}
+
+func TestProtection(t *testing.T) {
+	Debug("[ protection ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": {
+        "p1": [ "u2" ],
+        "p2": [ "u3", "u4" ]
+    },
+    "ruleset": {
+        "r1": [{ "pick": ["p1", "p2"] }]
+    }`)
+
+	Check(t, ruleset, "r1",
+		PR("pr1", "u1").Protect(3, false),
+		New("u2", "u3", "u4"), Pending("u2", "u3", "u4"), Assigned("u2", "u3", "u4"),
+		Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr2", "u1").Protect(3, false).Request("u2").Request("u3").Request("u4"),
+		New(), Pending("u2", "u3", "u4"), Assigned("u2", "u3", "u4"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr3", "u1").Protect(2, false).Review("u2", true).Review("u3", true),
+		New(), Pending(), Assigned("u2", "u3"), Requested(), Ready(true))
+
+	Check(t, ruleset, "r1",
+		PR("pr4", "u1").Protect(2, false).Review("u2", true).Request("u3").Review("u4", true),
+		New(), Pending("u3"), Assigned("u2", "u3"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr5", "u1").Protect(1, true).Own("u4").Request("u2").Request("u3"),
+		New("u4"), Pending("u2", "u3", "u4"), Assigned("u2", "u3", "u4"), Requested(), Ready(false))
+
+	Check(t, ruleset, "r1",
+		PR("pr6", "u1").Protect(1, true).Own("u4").Review("u2", true).Review("u3", true),
+		New("u4"), Pending("u4"), Assigned("u2", "u3", "u4"), Requested(), Ready(false))
+}
+
+func TestBlocked(t *testing.T) {
+	Debug("[ blocked ]==============================================")
+
+	ruleset := MakeRuleset(`
+    "pools": { "p1": [ "u2" ] },
+    "ruleset": {
+        "r1": [{ "pick": ["p1"] }]
+    }`)
+
+	repo := Repo{Path: "gups/repo", Rule: "r1"}
+
+	check := func(pr *PullRequest, blockers []string) {
+		t.Helper()
+
+		Check(t, ruleset, "r1", pr,
+			New(), Pending(), Assigned("u2"), Requested(), Ready(true))
+
+		if val := pr.Blockers(); strings.Join(val, ", ") != strings.Join(blockers, ", ") {
+			t.Errorf("%v-blockers: val=%v exp=%v", pr.Title, val, blockers)
+		}
+
+		exp := CategoryReady
+		if len(blockers) > 0 {
+			exp = CategoryBlocked
+		}
+
+		notifs := make(UserNotifications)
+		(&Engine{}).digest(ruleset, repo, pr, ruleset.Apply("r1", pr), notifs)
+
+		if len(notifs["u1"]) != 1 || notifs["u1"][0].Category != exp {
+			t.Errorf("%v-category: val=%v exp=%v", pr.Title, notifs["u1"], exp)
+		} else if reason := notifs["u1"][0].Reason; reason != strings.Join(blockers, ", ") {
+			t.Errorf("%v-reason: val=%v exp=%v", pr.Title, reason, blockers)
+		}
+	}
+
+	check(PR("pr1", "u1").Review("u2", true).Status("SUCCESS", "MERGEABLE", "APPROVED"), nil)
+
+	check(PR("pr2", "u1").Review("u2", true).Status("FAILURE", "MERGEABLE", "APPROVED"),
+		[]string{"CI failing"})
+
+	check(PR("pr3", "u1").Review("u2", true).Status("SUCCESS", "CONFLICTING", "APPROVED"),
+		[]string{"merge conflicts"})
+
+	check(PR("pr4", "u1").Review("u2", true).Status("SUCCESS", "MERGEABLE", "CHANGES_REQUESTED"),
+		[]string{"changes requested"})
+
+	check(PR("pr5", "u1").Review("u2", true).Status("ERROR", "CONFLICTING", "CHANGES_REQUESTED"),
+		[]string{"CI failing", "merge conflicts", "changes requested"})
+
+	pr := PR("pr6", "u1").Review("u2", true).Status("PENDING", "CONFLICTING", "APPROVED")
+	check(pr, []string{"CI pending", "merge conflicts"})
+	check(pr.Status("SUCCESS", "MERGEABLE", "APPROVED"), nil)
+}
+
+func TestDecline(t *testing.T) {
+	Debug("[ decline ]=================================================")
+
+	ruleset := MakeRuleset(`
+    "pools": { "p1": [ "u2", "u3", "u4" ] },
+    "ruleset": {
+        "r1": [{ "pick": ["p1:2"] }]
+    }`)
+	ruleset.Decline(NewSet("u2"))
+
+	pr := PR("pr1", "u1").Request("u2").Request("u3")
+	Check(t, ruleset, "r1", pr,
+		New("u4"), Pending("u3", "u4"), Assigned("u3", "u4"), Requested(), Ready(false))
+	CheckSet(t, "pr1-removed", NewSet("u2"), ruleset.Apply("r1", pr).Removed)
+
+	pr = PR("pr2", "u1").Request("u3").Review("u2", true)
+	Check(t, ruleset, "r1", pr,
+		New(), Pending("u3"), Assigned("u2", "u3"), Requested(), Ready(false))
+	CheckSet(t, "pr2-removed", NewSet(), ruleset.Apply("r1", pr).Removed)
+}
+
+func TestMatchedRule(t *testing.T) {
+	Debug("[ matched rule ]============================================")
+
+	ruleset := MakeRuleset(`
+    "skip_pr_labels": [ "wip" ],
+    "pools": { "p1": [ "u1", "u2" ], "p2": [ "u3" ] },
+    "ruleset": {
+        "r1": [
+            { "if": "p2", "pick": ["p1"] },
+            { "if_label": "urgent", "when": "!draft", "pick": ["p2"] }
+        ]
+    }`)
+
+	check := func(pr *PullRequest, exp int) {
+		if val := ruleset.Apply("r1", pr).Rule; val != exp {
+			t.Errorf("%v-rule: val=%v exp=%v", pr.Title, val, exp)
+		}
+	}
+
+	check(PR("pr1", "u3"), 0)
+	check(PR("pr2", "u1").Label("urgent"), 1)
+	check(PR("pr3", "u1"), -1)
+	check(PR("pr4", "u3").Label("wip"), -1)
+
+	conds := ruleset.ruleset["r1"].Rules[1].Conditions()
+	if exp := `label("urgent") && (!draft)`; conds != exp {
+		t.Errorf("conditions: val=%v exp=%v", conds, exp)
+	}
+}
+
 func MakeRuleset(body string) *Ruleset {
 	json := fmt.Sprintf(`
 {
@@ -147,11 +834,83 @@ func (pr *PullRequest) Review(user string, approved bool) *PullRequest {
 	return pr
 }
 
+func (pr *PullRequest) ReviewAs(user, state string, ts time.Time) *PullRequest {
+	pr.Reviews = append(pr.Reviews, Review{Author: user, State: state, Time: ts})
+	return pr
+}
+
+func (pr *PullRequest) Commit(oid string, merge bool) *PullRequest {
+	pr.Commits = append(pr.Commits, Commit{Oid: oid, Merge: merge})
+	pr.Head = oid
+	return pr
+}
+
+func (pr *PullRequest) ApproveAt(user, oid string) *PullRequest {
+	return pr.ReviewOn(user, ReviewApproved, oid)
+}
+
+func (pr *PullRequest) ReviewOn(user, state, oid string) *PullRequest {
+	pr.Reviews = append(pr.Reviews, Review{Author: user, State: state, Commit: oid})
+	return pr
+}
+
+func (pr *PullRequest) Protect(approvals int, codeOwners bool) *PullRequest {
+	pr.RequiredApprovals = approvals
+	pr.RequiresCodeOwners = codeOwners
+	return pr
+}
+
 func (pr *PullRequest) Request(user string) *PullRequest {
 	pr.ReviewRequests.Put(user)
 	return pr
 }
 
+func (pr *PullRequest) RequestAt(user string, ts time.Time) *PullRequest {
+	if pr.RequestedAt == nil {
+		pr.RequestedAt = make(map[string]time.Time)
+	}
+	pr.RequestedAt[user] = ts
+	return pr.Request(user)
+}
+
+func (pr *PullRequest) Label(labels ...string) *PullRequest {
+	if pr.Labels == nil {
+		pr.Labels = NewSet()
+	}
+	pr.Labels.Add(NewSet(labels...))
+	return pr
+}
+
+func (pr *PullRequest) Own(users ...string) *PullRequest {
+	pr.CodeOwners = NewSet(users...)
+	return pr
+}
+
+func (pr *PullRequest) Touch(files ...string) *PullRequest {
+	pr.Files = append(pr.Files, files...)
+	return pr
+}
+
+func (pr *PullRequest) Status(checks, mergeable, decision string) *PullRequest {
+	pr.Checks = checks
+	pr.Mergeable = mergeable
+	pr.ReviewDecision = decision
+	return pr
+}
+
+func (pr *PullRequest) AsDraft() *PullRequest {
+	pr.Draft = true
+	return pr
+}
+
+func (pr *PullRequest) RequestTeam(users ...string) *PullRequest {
+	if pr.TeamReviewRequests == nil {
+		pr.TeamReviewRequests = NewSet()
+	}
+	pr.TeamReviewRequests.Add(NewSet(users...))
+	return pr
+}
+
 func New(users ...string) Set {
 	return NewSet(users...)
 }
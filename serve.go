@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Serve executes the configured jobs on their schedule and, if configured,
+// listens for webhooks until a SIGTERM or SIGINT is received at which point it
+// waits for any running job to complete before returning.
+func Serve(engine *Engine) {
+	config := &engine.config.Serve
+	if len(config.Jobs) == 0 && config.Listen == "" {
+		Fatal("no jobs or listen address configured in 'serve'")
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	defer engine.Wait()
+
+	if config.Listen != "" {
+		mux := http.NewServeMux()
+
+		if secret := os.Getenv("GITHUB_WEBHOOK_SECRET"); secret != "" {
+			mux.Handle("/github", GithubHandler(engine, secret))
+		}
+
+		if secret := os.Getenv("SLACK_SIGNING_SECRET"); secret != "" {
+			mux.Handle("/slack/actions", SlackActionsHandler(engine, secret))
+			mux.Handle("/slack/commands", SlackCommandHandler(engine, secret))
+			engine.interactive = true
+		}
+
+		// Webhooks reuse the review load of the last job so it must be
+		// available before the first job runs.
+		engine.Async(engine.SeedLoad)
+
+		server := &http.Server{Addr: config.Listen, Handler: mux}
+		defer shutdown(server)
+
+		go func() {
+			Info("listening on %v", config.Listen)
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				Fatal("unable to listen on '%v': %v", config.Listen, err)
+			}
+		}()
+	}
+
+	for {
+		now := time.Now().In(config.location)
+
+		next := time.Time{}
+		for _, job := range config.Jobs {
+			if ts := job.schedule.Next(now); !ts.IsZero() && (next.IsZero() || ts.Before(next)) {
+				next = ts
+			}
+		}
+
+		var timer *time.Timer
+		var wakeup <-chan time.Time
+		if !next.IsZero() {
+			Info("next run at %v", next)
+			timer = time.NewTimer(next.Sub(now))
+			wakeup = timer.C
+		}
+
+		select {
+
+		case sig := <-signals:
+			if timer != nil {
+				timer.Stop()
+			}
+			Info("received %v, shutting down...", sig)
+			return
+
+		case <-wakeup:
+			for _, job := range config.Jobs {
+				if !job.schedule.Next(next.Add(-time.Minute)).Equal(next) {
+					continue
+				}
+
+				job := job
+				engine.Async(func() {
+					Info("running job '%v'...", job.Name)
+					engine.Run(job.Full)
+				})
+			}
+		}
+	}
+}
+
+func shutdown(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		Warning("unable to shutdown http server: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"sort"
+	"strings"
 )
 
 type Set map[string]struct{}
@@ -24,6 +25,16 @@ func (set Set) Copy() Set {
 	return result
 }
 
+// Fold returns a copy of the set where every item is in lower case which is
+// used to compare Github logins as they're case-insensitive.
+func (set Set) Fold() Set {
+	result := make(Set)
+	for item, _ := range set {
+		result.Put(strings.ToLower(item))
+	}
+	return result
+}
+
 func (set Set) Empty() bool {
 	return len(set) == 0
 }
@@ -126,6 +137,55 @@ func (set Set) Pick(n int) Set {
 	return NewSet(arr[0:n]...)
 }
 
+// PickLeastLoaded picks the n items with the lowest load where ties are broken
+// randomly.
+func (set Set) PickLeastLoaded(n int, load map[string]int) Set {
+	if n >= len(set) {
+		return set
+	}
+
+	arr := set.ToArray()
+	rand.Shuffle(len(arr), func(i, j int) {
+		arr[i], arr[j] = arr[j], arr[i]
+	})
+	sort.SliceStable(arr, func(i, j int) bool {
+		return load[arr[i]] < load[arr[j]]
+	})
+	return NewSet(arr[0:n]...)
+}
+
+// PickWeighted picks n items at random where the probability of picking an item
+// is proportional to its weight. Items are iterated in sorted order such that
+// the result is deterministic for a given random seed.
+func (set Set) PickWeighted(n int, weight func(item string) float64) Set {
+	if n >= len(set) {
+		return set
+	}
+
+	arr := set.ToArray()
+	result := NewSet()
+
+	for ; n > 0; n-- {
+		total := 0.0
+		for _, item := range arr {
+			total += weight(item)
+		}
+
+		index := len(arr) - 1
+		for i, target := 0, rand.Float64()*total; i < len(arr); i++ {
+			if target -= weight(arr[i]); target < 0 {
+				index = i
+				break
+			}
+		}
+
+		result.Put(arr[index])
+		arr = append(arr[:index], arr[index+1:]...)
+	}
+
+	return result
+}
+
 func (set Set) String() string {
 	return fmt.Sprintf("%v", set.ToArray())
 }
@@ -9,20 +9,32 @@ import (
 	"net/http"
 	"os"
 	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/nlopes/slack"
 )
 
 const IconURL = "https://github.com/RAttab/gups/blob/master/gups.png?raw=true"
+
+var InspirationURL = "https://icanhazdadjoke.com/"
+
 const MsgLimit = 40000
 const TruncateFooter = "\n..."
+const MaxBlocks = 50
+const MaxSectionText = 3000
 
 type Category int
 
 const (
 	CategoryAssigned Category = iota
+	CategoryRereview
+	CategoryEscalated
 	CategoryPending
 	CategoryReady
+	CategoryBlocked
+	CategoryAwaitingAuthor
 	CategoryOpen
 	CategoryRequested
 )
@@ -31,10 +43,18 @@ func (cat Category) String() string {
 	switch cat {
 	case CategoryAssigned:
 		return "*Assigned*"
+	case CategoryRereview:
+		return "*Re-review*"
+	case CategoryEscalated:
+		return "*Escalated*"
 	case CategoryReady:
-		return "*Ready*"
+		return "*Ready to Merge*"
+	case CategoryBlocked:
+		return "*Approved but Blocked*"
 	case CategoryPending:
 		return "*Pending*"
+	case CategoryAwaitingAuthor:
+		return "*Awaiting Author*"
 	case CategoryOpen:
 		return "*Open*"
 	case CategoryRequested:
@@ -44,10 +64,63 @@ func (cat Category) String() string {
 	return "meep"
 }
 
+// Name returns the identifier of the category used when persisting
+// notifications.
+func (cat Category) Name() string {
+	switch cat {
+	case CategoryAssigned:
+		return "assigned"
+	case CategoryRereview:
+		return "rereview"
+	case CategoryEscalated:
+		return "escalated"
+	case CategoryReady:
+		return "ready"
+	case CategoryBlocked:
+		return "blocked"
+	case CategoryPending:
+		return "pending"
+	case CategoryAwaitingAuthor:
+		return "awaiting-author"
+	case CategoryOpen:
+		return "open"
+	case CategoryRequested:
+		return "requested"
+	}
+	Fatal("unkown category '%v'", cat)
+	return "meep"
+}
+
+func CategoryByName(name string) (Category, bool) {
+	for cat := CategoryAssigned; cat <= CategoryRequested; cat++ {
+		if cat.Name() == name {
+			return cat, true
+		}
+	}
+	return -1, false
+}
+
+// OneShot indicates whether entries of the category are only produced when
+// Gups modifies the review requests of a PR.
+func (cat Category) OneShot() bool {
+	return cat == CategoryAssigned || cat == CategoryRereview || cat == CategoryEscalated
+}
+
+// Group returns the category used to detect changes between notifications
+// where the categories of a reviewer's pending review are equivalent.
+func (cat Category) Group() Category {
+	if cat.OneShot() {
+		return CategoryPending
+	}
+	return cat
+}
+
 type Notification struct {
 	Category Category
 	Path     string
 	PR       *PullRequest
+	Waiting  *Age
+	Reason   string
 }
 
 type Notifications []Notification
@@ -73,7 +146,20 @@ func (n Notifications) Less(i, j int) bool {
 type UserNotifications map[string]Notifications
 
 func (n UserNotifications) Add(cat Category, user, repo string, pr *PullRequest) {
-	n[user] = append(n[user], Notification{cat, repo, pr})
+	notif := Notification{Category: cat, Path: repo, PR: pr}
+
+	if cat == CategoryBlocked {
+		notif.Reason = strings.Join(pr.Blockers(), ", ")
+	}
+
+	if cat == CategoryAssigned || cat == CategoryPending {
+		if ts, ok := pr.RequestedAt[user]; ok {
+			age := NewAge(ts)
+			notif.Waiting = &age
+		}
+	}
+
+	n[user] = append(n[user], notif)
 }
 
 func ConnectSlack() (*slack.Client, error) {
@@ -86,14 +172,11 @@ func ConnectSlack() (*slack.Client, error) {
 
 type SlackUsers map[string]string
 
-func SlackMapUsers(client *slack.Client, config *Config) SlackUsers {
+// SlackMapUsers maps the configured Github users to the id of their Slack user
+// amongst the given Slack users.
+func SlackMapUsers(config *Config, slackUsers []slack.User) SlackUsers {
 	users := make(SlackUsers)
 
-	slackUsers, err := client.GetUsers()
-	if err != nil {
-		log.Fatalf("unable to get slack users: %v", err)
-	}
-
 	slackIds := make(map[string]string)
 	for _, user := range slackUsers {
 		slackIds[user.Name] = user.ID
@@ -110,6 +193,72 @@ func SlackMapUsers(client *slack.Client, config *Config) SlackUsers {
 	return users
 }
 
+// SlackUnavailable returns the Github users whose Slack status, as found in the
+// given Slack users, matches one of the configured patterns or, if enabled, that
+// are currently in do-not-disturb mode.
+func SlackUnavailable(
+	client *slack.Client, config *Config, slackUsers []slack.User, users SlackUsers, now time.Time) (Set, error) {
+
+	var dnd map[string]slack.DNDStatus
+	if config.SlackStatus.DND && len(users) > 0 {
+		var ids []string
+		for _, id := range users {
+			ids = append(ids, id)
+		}
+
+		var err error
+		if dnd, err = client.GetDNDTeamInfo(ids); err != nil {
+			return nil, fmt.Errorf("unable to get slack dnd status: %v", err)
+		}
+	}
+
+	return slackAway(config, slackUsers, users, dnd, now), nil
+}
+
+// slackAway returns the Github users whose Slack status is away or that are in
+// do-not-disturb mode according to the given DND statuses indexed by Slack id.
+func slackAway(
+	config *Config, slackUsers []slack.User, users SlackUsers, dnd map[string]slack.DNDStatus, now time.Time) Set {
+
+	result := NewSet()
+
+	githubIds := make(map[string]string)
+	for github, id := range users {
+		githubIds[id] = github
+	}
+
+	for _, user := range slackUsers {
+		github, ok := githubIds[user.ID]
+		if !ok {
+			continue
+		}
+
+		profile := user.Profile
+		if expiration := profile.StatusExpiration; expiration != 0 && int64(expiration) < now.Unix() {
+			continue
+		}
+
+		if config.SlackStatus.Away(profile.StatusEmoji + " " + profile.StatusText) {
+			Info("slack user '%v' is away: %v %v", user.Name, profile.StatusEmoji, profile.StatusText)
+			result.Put(github)
+		}
+	}
+
+	for id, status := range dnd {
+		if _, ok := githubIds[id]; !ok || !status.Enabled {
+			continue
+		}
+
+		start, end := int64(status.NextStartTimestamp), int64(status.NextEndTimestamp)
+		if start <= now.Unix() && now.Unix() < end {
+			Info("slack user '%v' is in do-not-disturb mode", githubIds[id])
+			result.Put(githubIds[id])
+		}
+	}
+
+	return result
+}
+
 func SlackDumpUsers(client *slack.Client) {
 	users, err := client.GetUsers()
 	if err != nil {
@@ -122,7 +271,7 @@ func SlackDumpUsers(client *slack.Client) {
 }
 
 func inspiration() (string, error) {
-	req, err := http.NewRequest("GET", "https://icanhazdadjoke.com/", nil)
+	req, err := http.NewRequest("GET", InspirationURL, nil)
 	if err != nil {
 		return "", err
 	}
@@ -143,7 +292,46 @@ func inspiration() (string, error) {
 	return string(body), err
 }
 
-func NotifySlack(client *slack.Client, user string, notif Notifications, dryRun bool) error {
+// actionable indicates whether the entries of the category can be acted upon
+// by the reviewer through the Slack actions.
+func (cat Category) actionable() bool {
+	return cat == CategoryAssigned || cat == CategoryRereview || cat == CategoryPending
+}
+
+func entryActions(entry Notification) *slack.Accessory {
+	var options []*slack.OptionBlockObject
+	for _, action := range EntryActions {
+		value := FormatEntryAction(action, entry.Path, int(entry.PR.Number))
+		text := slack.NewTextBlockObject(slack.PlainTextType, action.String(), false, false)
+		options = append(options, slack.NewOptionBlockObject(value, text))
+	}
+
+	return slack.NewAccessory(slack.NewOverflowBlockElement(EntryActionId, options...))
+}
+
+// formatEntry returns the line of the entry in the notification which includes
+// the age of the PR and, if known, how long the review has been waiting on the
+// user.
+func formatEntry(entry Notification) string {
+	age := entry.PR.Age.String()
+	if entry.Waiting != nil {
+		age = fmt.Sprintf("%v, waiting %v", age, entry.Waiting)
+	}
+
+	line := fmt.Sprintf("- [%v] *<https://github.com/%v/pull/%v|%v/%v>*: %v",
+		age,
+		entry.Path, entry.PR.Number,
+		entry.Path, entry.PR.Number,
+		entry.PR.Title)
+
+	if entry.Reason != "" {
+		line += fmt.Sprintf(" _(%v)_", entry.Reason)
+	}
+
+	return line
+}
+
+func NotifySlack(client *slack.Client, user string, notif Notifications, interactive, dryRun bool) error {
 	sort.Sort(notif)
 
 	if false { // DEBUG
@@ -153,25 +341,43 @@ func NotifySlack(client *slack.Client, user string, notif Notifications, dryRun
 
 	var currCategory Category = -1
 	buffer := bytes.Buffer{}
+	var blocks []slack.Block
+	var texts []string
+
+	section := func(text string, accessory *slack.Accessory) {
+		if utf8.RuneCountInString(text) > MaxSectionText {
+			runes := []rune(text)
+			text = string(runes[:MaxSectionText-len(TruncateFooter)]) + TruncateFooter
+		}
+		obj := slack.NewTextBlockObject(slack.MarkdownType, text, false, false)
+		blocks = append(blocks, slack.NewSectionBlock(obj, nil, accessory))
+		texts = append(texts, text)
+	}
 
 	for _, entry := range notif {
 
 		if currCategory != entry.Category {
 			currCategory = entry.Category
 			buffer.WriteString(fmt.Sprintf("%v:\n", currCategory))
+			section(currCategory.String(), nil)
+		}
+
+		line := formatEntry(entry)
+
+		var accessory *slack.Accessory
+		if entry.Category.actionable() {
+			accessory = entryActions(entry)
 		}
+		section(line, accessory)
 
-		line := fmt.Sprintf("- [%v] *<https://github.com/%v/pull/%v|%v/%v>*: %v\n",
-			entry.PR.Age,
-			entry.Path, entry.PR.Number,
-			entry.Path, entry.PR.Number,
-			entry.PR.Title)
+		line += "\n"
 
 		if buffer.Len()+len(line) <= MsgLimit {
 			buffer.WriteString(line)
 		} else {
 			buffer.WriteString(TruncateFooter)
 			log.Printf("truncated")
+			interactive = false
 			break
 		}
 	}
@@ -184,21 +390,45 @@ func NotifySlack(client *slack.Client, user string, notif Notifications, dryRun
 
 		if buffer.Len()+len(line) <= MsgLimit {
 			buffer.WriteString(line)
+			section(line, nil)
 		}
 	}
 
 	if dryRun {
 		log.Printf("%v", buffer.String())
+		return nil
+	}
 
-	} else {
-		_, _, err := client.PostMessage(user,
+	post := func(text string, blocks []slack.Block) error {
+		options := []slack.MsgOption{
 			slack.MsgOptionUsername("GUPS"),
 			slack.MsgOptionAsUser(false),
-			slack.MsgOptionText(buffer.String(), false),
+			slack.MsgOptionText(text, false),
 			slack.MsgOptionIconURL(IconURL),
-			slack.MsgOptionDisableLinkUnfurl())
+			slack.MsgOptionDisableLinkUnfurl(),
+		}
+
+		if len(blocks) > 0 {
+			options = append(options, slack.MsgOptionBlocks(blocks...))
+		}
+
+		_, _, err := client.PostMessage(user, options...)
+		return err
+	}
+
+	if !interactive {
+		return post(buffer.String(), nil)
+	}
+
+	// Slack limits the number of blocks of a message so the digest is split
+	// into multiple messages to keep the actions of every entry.
+	for start := 0; start < len(blocks); start += MaxBlocks {
+		end := start + MaxBlocks
+		if end > len(blocks) {
+			end = len(blocks)
+		}
 
-		if err != nil {
+		if err := post(strings.Join(texts[start:end], "\n"), blocks[start:end]); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nlopes/slack"
+)
+
+func TestSlackStatus(t *testing.T) {
+	Debug("[ slack status ]========================================")
+
+	config := ParseConfig("test", []byte(`
+{
+    "repos": [{ "path": "gups/repo", "rule": "r1" }],
+    "github_to_slack_user": { "u1": "s1", "u2": "s2", "u3": "s3", "u4": "s4", "u5": "s5" },
+    "slack_status": { "patterns": [ ":palm_tree:", "out of office" ], "dnd": true },
+    "pools": { "p1": [ "u1" ] },
+    "ruleset": { "r1": [{ "pick": ["p1"] }] }
+}`))
+
+	for _, test := range []struct {
+		text string
+		away bool
+	}{
+		{":palm_tree: Vacationing", true},
+		{" Out Of Office", true},
+		{":calendar: In a meeting", false},
+		{" ", false},
+	} {
+		if val := config.SlackStatus.Away(test.text); val != test.away {
+			t.Errorf("away '%v': val=%v exp=%v", test.text, val, test.away)
+		}
+	}
+
+	now := time.Now()
+	user := func(id, emoji, text string, expiration time.Time) slack.User {
+		user := slack.User{ID: id, Name: id}
+		user.Profile.StatusEmoji = emoji
+		user.Profile.StatusText = text
+		if !expiration.IsZero() {
+			user.Profile.StatusExpiration = int(expiration.Unix())
+		}
+		return user
+	}
+
+	slackUsers := []slack.User{
+		user("S1", ":palm_tree:", "Vacationing", time.Time{}),
+		user("S2", ":palm_tree:", "Vacationing", now.Add(-time.Hour)),
+		user("S3", ":palm_tree:", "Vacationing", now.Add(time.Hour)),
+		user("S4", "", "", time.Time{}),
+		user("S5", "", "", time.Time{}),
+		user("S6", ":palm_tree:", "Vacationing", time.Time{}),
+	}
+
+	users := SlackUsers{"u1": "S1", "u2": "S2", "u3": "S3", "u4": "S4", "u5": "S5"}
+
+	CheckSet(t, "status", NewSet("u1", "u3"), slackAway(config, slackUsers, users, nil, now))
+
+	dnd := map[string]slack.DNDStatus{
+		"S4": {Enabled: true, NextStartTimestamp: int(now.Add(-time.Hour).Unix()),
+			NextEndTimestamp: int(now.Add(time.Hour).Unix())},
+		"S5": {Enabled: true, NextStartTimestamp: int(now.Add(time.Hour).Unix()),
+			NextEndTimestamp: int(now.Add(2 * time.Hour).Unix())},
+		"S2": {Enabled: false, NextStartTimestamp: int(now.Add(-time.Hour).Unix()),
+			NextEndTimestamp: int(now.Add(time.Hour).Unix())},
+		"S6": {Enabled: true, NextStartTimestamp: int(now.Add(-time.Hour).Unix()),
+			NextEndTimestamp: int(now.Add(time.Hour).Unix())},
+	}
+
+	CheckSet(t, "dnd", NewSet("u1", "u3", "u4"), slackAway(config, slackUsers, users, dnd, now))
+}
+
+func TestWaiting(t *testing.T) {
+	Debug("[ waiting ]=============================================")
+
+	now := time.Now()
+	pr := PR("pr1", "u1").RequestAt("u2", now.Add(-3*24*time.Hour-time.Minute)).Request("u3")
+	pr.Number = 1
+	pr.Age = NewAge(now.Add(-5*24*time.Hour - time.Minute))
+
+	notifs := make(UserNotifications)
+	notifs.Add(CategoryPending, "u2", "org/repo", pr)
+	notifs.Add(CategoryPending, "u3", "org/repo", pr)
+	notifs.Add(CategoryAssigned, "u2", "org/repo", pr)
+	notifs.Add(CategoryOpen, "u1", "org/repo", pr)
+
+	for _, test := range []struct {
+		title string
+		entry Notification
+		exp   string
+	}{
+		{"pending", notifs["u2"][0], "- [5d, waiting 3d] *<https://github.com/org/repo/pull/1|org/repo/1>*: pr1"},
+		{"pending-unknown", notifs["u3"][0], "- [5d] *<https://github.com/org/repo/pull/1|org/repo/1>*: pr1"},
+		{"assigned", notifs["u2"][1], "- [5d, waiting 3d] *<https://github.com/org/repo/pull/1|org/repo/1>*: pr1"},
+		{"open", notifs["u1"][0], "- [5d] *<https://github.com/org/repo/pull/1|org/repo/1>*: pr1"},
+	} {
+		if val := formatEntry(test.entry); val != test.exp {
+			t.Errorf("%v: val='%v' exp='%v'", test.title, val, test.exp)
+		}
+	}
+}
+
+func TestNotifySlack(t *testing.T) {
+	Debug("[ notify slack ]========================================")
+
+	quotes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("meep"))
+	}))
+	defer quotes.Close()
+
+	defer func(url string) { InspirationURL = url }(InspirationURL)
+	InspirationURL = quotes.URL
+
+	var messages [][]slack.SectionBlock
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		var blocks []slack.SectionBlock
+		if value := r.Form.Get("blocks"); value != "" {
+			if err := json.Unmarshal([]byte(value), &blocks); err != nil {
+				t.Errorf("malformed blocks: %v", err)
+			}
+		}
+		messages = append(messages, blocks)
+
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer api.Close()
+
+	client := slack.New("token", slack.OptionAPIURL(api.URL+"/"))
+
+	var notif Notifications
+	for i := 1; i <= 60; i++ {
+		title := fmt.Sprintf("pr%v", i)
+		if i == 1 {
+			title = "a" + strings.Repeat("é", MaxSectionText)
+		}
+
+		pr := PR(title, "u1")
+		pr.Number = int32(i)
+		notif = append(notif, Notification{Category: CategoryPending, Path: "org/repo", PR: pr})
+	}
+
+	check := func(title string, interactive bool, exp ...int) {
+		t.Helper()
+
+		messages = nil
+		if err := NotifySlack(client, "S1", notif, interactive, false); err != nil {
+			t.Fatalf("%v: %v", title, err)
+		}
+
+		if len(messages) != len(exp) {
+			t.Errorf("%v-messages: val=%v exp=%v", title, len(messages), len(exp))
+			return
+		}
+
+		actions := 0
+		for i, blocks := range messages {
+			if len(blocks) != exp[i] {
+				t.Errorf("%v-blocks-%v: val=%v exp=%v", title, i, len(blocks), exp[i])
+			}
+
+			for _, block := range blocks {
+				text := block.Text.Text
+				if !utf8.ValidString(text) || utf8.RuneCountInString(text) > MaxSectionText {
+					t.Errorf("%v-text: invalid section text of length %v", title, len(text))
+				}
+				if block.Accessory != nil {
+					actions++
+				}
+			}
+		}
+
+		if exp := len(notif); interactive && actions != exp {
+			t.Errorf("%v-actions: val=%v exp=%v", title, actions, exp)
+		}
+	}
+
+	// 60 entries along with the category header and the quote.
+	check("interactive", true, MaxBlocks, 12)
+	check("static", false, 0)
+}
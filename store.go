@@ -0,0 +1,681 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// AssignmentRecord records a review request made by Gups.
+type AssignmentRecord struct {
+	User    string    `json:"user"`
+	Path    string    `json:"path"`
+	Number  int       `json:"number"`
+	Ruleset string    `json:"ruleset"`
+	Rule    int       `json:"rule"`
+	Time    time.Time `json:"time"`
+}
+
+// EscalationRecord records a stale review request that was escalated to other
+// reviewers.
+type EscalationRecord struct {
+	User     string    `json:"user"`
+	Path     string    `json:"path"`
+	Number   int       `json:"number"`
+	Picked   []string  `json:"picked"`
+	Replaced bool      `json:"replaced"`
+	Time     time.Time `json:"time"`
+}
+
+// NotificationRecord records an entry sent to a user on Slack.
+type NotificationRecord struct {
+	User     string    `json:"user"`
+	Path     string    `json:"path"`
+	Number   int       `json:"number"`
+	Category string    `json:"category"`
+	Time     time.Time `json:"time"`
+}
+
+// Store persists the history of the actions taken by Gups along with the
+// snoozed and reviewed entries of the Slack actions.
+type Store interface {
+	AddAssignment(AssignmentRecord)
+	AddEscalation(EscalationRecord)
+	AddNotification(NotificationRecord)
+
+	Assignments(since time.Time) []AssignmentRecord
+	Escalations(since time.Time) []EscalationRecord
+	Notifications(since time.Time) []NotificationRecord
+
+	Snooze(key EntryKey, until time.Time)
+	Snoozed(key EntryKey) (time.Time, bool)
+	MarkReviewed(key EntryKey, head string)
+	Reviewed(key EntryKey) (string, bool)
+
+	// Flush persists the changes made to the store.
+	Flush() error
+}
+
+type StoreKind string
+
+const (
+	StoreMemory StoreKind = "memory"
+	StoreJSON   StoreKind = "json"
+	StoreBolt   StoreKind = "bolt"
+)
+
+const DefaultRetentionDays = 90
+
+type StateConfig struct {
+	Store         StoreKind `json:"store"`
+	Path          string    `json:"path"`
+	RetentionDays int       `json:"retention_days"`
+}
+
+// Persistent returns true if the state outlives the Gups process.
+func (config *StateConfig) Persistent() bool {
+	return config.Store != StoreMemory
+}
+
+// OpenStore opens the configured store where changes are never persisted if
+// readOnly is set.
+func OpenStore(config *StateConfig, readOnly bool) Store {
+	retention := time.Duration(config.RetentionDays) * 24 * time.Hour
+
+	switch config.Store {
+	case StoreMemory:
+		return NewMemoryStore(retention)
+	case StoreJSON:
+		return OpenJSONStore(config.Path, retention, readOnly)
+	case StoreBolt:
+		return OpenBoltStore(config.Path, retention, readOnly)
+	}
+
+	Fatal("unknown state store '%v'", config.Store)
+	return nil
+}
+
+type entryState struct {
+	Key      EntryKey  `json:"key"`
+	Snoozed  time.Time `json:"snoozed,omitempty"`
+	Reviewed string    `json:"reviewed,omitempty"`
+}
+
+type memoryState struct {
+	Assignments   []AssignmentRecord   `json:"assignments"`
+	Escalations   []EscalationRecord   `json:"escalations"`
+	Notifications []NotificationRecord `json:"notifications"`
+	Entries       []entryState         `json:"entries"`
+}
+
+// MemoryStore keeps the state in memory and is lost whenever Gups exits.
+// Records older than the retention period are dropped when flushing unless the
+// retention is zero.
+type MemoryStore struct {
+	lock      sync.Mutex
+	state     memoryState
+	entries   map[EntryKey]*entryState
+	retention time.Duration
+
+	// dirty indicates that the state changed since it was last persisted.
+	dirty bool
+}
+
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{entries: make(map[EntryKey]*entryState), retention: retention}
+}
+
+func (store *MemoryStore) AddAssignment(assignment AssignmentRecord) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.state.Assignments = append(store.state.Assignments, assignment)
+	store.dirty = true
+}
+
+func (store *MemoryStore) AddEscalation(escalation EscalationRecord) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.state.Escalations = append(store.state.Escalations, escalation)
+	store.dirty = true
+}
+
+func (store *MemoryStore) AddNotification(notif NotificationRecord) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.state.Notifications = append(store.state.Notifications, notif)
+	store.dirty = true
+}
+
+func (store *MemoryStore) Assignments(since time.Time) []AssignmentRecord {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var result []AssignmentRecord
+	for _, assignment := range store.state.Assignments {
+		if !assignment.Time.Before(since) {
+			result = append(result, assignment)
+		}
+	}
+	return result
+}
+
+func (store *MemoryStore) Escalations(since time.Time) []EscalationRecord {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var result []EscalationRecord
+	for _, escalation := range store.state.Escalations {
+		if !escalation.Time.Before(since) {
+			result = append(result, escalation)
+		}
+	}
+	return result
+}
+
+func (store *MemoryStore) Notifications(since time.Time) []NotificationRecord {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var result []NotificationRecord
+	for _, notif := range store.state.Notifications {
+		if !notif.Time.Before(since) {
+			result = append(result, notif)
+		}
+	}
+	return result
+}
+
+func (store *MemoryStore) entry(key EntryKey) *entryState {
+	entry, ok := store.entries[key]
+	if !ok {
+		entry = &entryState{Key: key}
+		store.entries[key] = entry
+	}
+	return entry
+}
+
+func (store *MemoryStore) Snooze(key EntryKey, until time.Time) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.entry(key).Snoozed = until
+	store.dirty = true
+}
+
+func (store *MemoryStore) Snoozed(key EntryKey) (time.Time, bool) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if entry, ok := store.entries[key]; ok && !entry.Snoozed.IsZero() {
+		return entry.Snoozed, true
+	}
+	return time.Time{}, false
+}
+
+func (store *MemoryStore) MarkReviewed(key EntryKey, head string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.entry(key).Reviewed = head
+	store.dirty = true
+}
+
+func (store *MemoryStore) Reviewed(key EntryKey) (string, bool) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if entry, ok := store.entries[key]; ok && entry.Reviewed != "" {
+		return entry.Reviewed, true
+	}
+	return "", false
+}
+
+func (store *MemoryStore) Flush() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.prune()
+	return nil
+}
+
+// prune removes the records older than the retention period along with the
+// entries that no longer carry any state.
+func (store *MemoryStore) prune() {
+	if store.retention == 0 {
+		return
+	}
+
+	before := time.Now().Add(-store.retention)
+	count := len(store.state.Assignments) + len(store.state.Escalations) +
+		len(store.state.Notifications) + len(store.entries)
+
+	assignments := store.state.Assignments[:0]
+	for _, assignment := range store.state.Assignments {
+		if !assignment.Time.Before(before) {
+			assignments = append(assignments, assignment)
+		}
+	}
+	store.state.Assignments = assignments
+
+	escalations := store.state.Escalations[:0]
+	for _, escalation := range store.state.Escalations {
+		if !escalation.Time.Before(before) {
+			escalations = append(escalations, escalation)
+		}
+	}
+	store.state.Escalations = escalations
+
+	notifs := store.state.Notifications[:0]
+	for _, notif := range store.state.Notifications {
+		if !notif.Time.Before(before) {
+			notifs = append(notifs, notif)
+		}
+	}
+	store.state.Notifications = notifs
+
+	now := time.Now()
+	for key, entry := range store.entries {
+		if entry.Reviewed == "" && entry.Snoozed.Before(now) {
+			delete(store.entries, key)
+		}
+	}
+
+	if count != len(store.state.Assignments)+len(store.state.Escalations)+
+		len(store.state.Notifications)+len(store.entries) {
+		store.dirty = true
+	}
+}
+
+// JSONStore is a MemoryStore that is loaded from and flushed to a JSON file.
+// The whole file is rewritten whenever the state changed which makes it best
+// suited to the one-shot mode.
+type JSONStore struct {
+	*MemoryStore
+
+	path     string
+	readOnly bool
+}
+
+func OpenJSONStore(path string, retention time.Duration, readOnly bool) *JSONStore {
+	store := &JSONStore{
+		MemoryStore: NewMemoryStore(retention),
+		path:        path,
+		readOnly:    readOnly,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		Info("creating new state file '%v'", path)
+		return store
+	} else if err != nil {
+		Fatal("unable to open '%v': %v", path, err)
+	}
+
+	if err := json.Unmarshal(data, &store.state); err != nil {
+		Fatal("unable to parse '%v': %v", path, err)
+	}
+
+	for index := range store.state.Entries {
+		entry := store.state.Entries[index]
+		store.entries[entry.Key] = &entry
+	}
+	store.state.Entries = nil
+
+	return store
+}
+
+func (store *JSONStore) Flush() error {
+	if store.readOnly {
+		return nil
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.prune()
+	if !store.dirty {
+		return nil
+	}
+
+	state := store.state
+	for _, entry := range store.entries {
+		state.Entries = append(state.Entries, *entry)
+	}
+
+	data, err := json.MarshalIndent(&state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), store.path); err != nil {
+		return err
+	}
+
+	store.dirty = false
+	return nil
+}
+
+var (
+	boltAssignments   = []byte("assignments")
+	boltEscalations   = []byte("escalations")
+	boltNotifications = []byte("notifications")
+	boltEntries       = []byte("entries")
+)
+
+// BoltStore persists the state in a BoltDB file where every change is written
+// as it's made. Records are indexed by time and the ones older than the
+// retention period are dropped when flushing.
+type BoltStore struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+// OpenBoltStore opens the BoltDB file at the given path. If readOnly is set then
+// the file is loaded in a MemoryStore such that changes are never persisted.
+func OpenBoltStore(path string, retention time.Duration, readOnly bool) Store {
+	if readOnly {
+		return loadBoltStore(path, retention)
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		Info("creating new state file '%v'", path)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		Fatal("unable to open '%v': %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltAssignments, boltEscalations, boltNotifications, boltEntries} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		Fatal("unable to initialize '%v': %v", path, err)
+	}
+
+	return &BoltStore{db: db, retention: retention}
+}
+
+func loadBoltStore(path string, retention time.Duration) Store {
+	result := NewMemoryStore(retention)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return result
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		Fatal("unable to open '%v': %v", path, err)
+	}
+	defer db.Close()
+
+	store := &BoltStore{db: db}
+	result.state.Assignments = store.Assignments(time.Time{})
+	result.state.Escalations = store.Escalations(time.Time{})
+	result.state.Notifications = store.Notifications(time.Time{})
+
+	store.scan(boltEntries, nil, func(data []byte) error {
+		entry := &entryState{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			return err
+		}
+		result.entries[entry.Key] = entry
+		return nil
+	})
+
+	return result
+}
+
+// boltKey returns the key of a record made at the given time where seq makes
+// the key unique amongst the records made at the same time.
+func boltKey(ts time.Time, seq uint64) []byte {
+	nanos := uint64(0)
+	if ts.After(time.Unix(0, 0)) {
+		nanos = uint64(ts.UnixNano())
+	}
+
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, nanos)
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func (store *BoltStore) add(bucket []byte, ts time.Time, record interface{}) {
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		records := tx.Bucket(bucket)
+		seq, err := records.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		return records.Put(boltKey(ts, seq), data)
+	})
+
+	if err != nil {
+		Warning("unable to write %v to the state store: %v", string(bucket), err)
+	}
+}
+
+// scan calls fn on the values of the bucket starting from the given key or from
+// the first key if nil.
+func (store *BoltStore) scan(bucket, from []byte, fn func(data []byte) error) {
+	err := store.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(bucket).Cursor()
+
+		key, value := cursor.First()
+		if from != nil {
+			key, value = cursor.Seek(from)
+		}
+
+		for ; key != nil; key, value = cursor.Next() {
+			if err := fn(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		Warning("unable to read %v from the state store: %v", string(bucket), err)
+	}
+}
+
+func (store *BoltStore) AddAssignment(assignment AssignmentRecord) {
+	store.add(boltAssignments, assignment.Time, assignment)
+}
+
+func (store *BoltStore) AddEscalation(escalation EscalationRecord) {
+	store.add(boltEscalations, escalation.Time, escalation)
+}
+
+func (store *BoltStore) AddNotification(notif NotificationRecord) {
+	store.add(boltNotifications, notif.Time, notif)
+}
+
+func (store *BoltStore) Assignments(since time.Time) []AssignmentRecord {
+	var result []AssignmentRecord
+	store.scan(boltAssignments, boltKey(since, 0), func(data []byte) error {
+		var assignment AssignmentRecord
+		if err := json.Unmarshal(data, &assignment); err != nil {
+			return err
+		}
+		result = append(result, assignment)
+		return nil
+	})
+	return result
+}
+
+func (store *BoltStore) Escalations(since time.Time) []EscalationRecord {
+	var result []EscalationRecord
+	store.scan(boltEscalations, boltKey(since, 0), func(data []byte) error {
+		var escalation EscalationRecord
+		if err := json.Unmarshal(data, &escalation); err != nil {
+			return err
+		}
+		result = append(result, escalation)
+		return nil
+	})
+	return result
+}
+
+func (store *BoltStore) Notifications(since time.Time) []NotificationRecord {
+	var result []NotificationRecord
+	store.scan(boltNotifications, boltKey(since, 0), func(data []byte) error {
+		var notif NotificationRecord
+		if err := json.Unmarshal(data, &notif); err != nil {
+			return err
+		}
+		result = append(result, notif)
+		return nil
+	})
+	return result
+}
+
+func (store *BoltStore) entry(key EntryKey) (entryState, bool) {
+	entry := entryState{Key: key}
+	found := false
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		id, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+
+		data := tx.Bucket(boltEntries).Get(id)
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(data, &entry)
+	})
+
+	if err != nil {
+		Warning("unable to read entry %v from the state store: %v", key, err)
+		return entry, false
+	}
+
+	return entry, found
+}
+
+func (store *BoltStore) updateEntry(key EntryKey, fn func(entry *entryState)) {
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		id, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+
+		entries := tx.Bucket(boltEntries)
+
+		entry := entryState{Key: key}
+		if data := entries.Get(id); data != nil {
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+		}
+
+		fn(&entry)
+
+		data, err := json.Marshal(&entry)
+		if err != nil {
+			return err
+		}
+		return entries.Put(id, data)
+	})
+
+	if err != nil {
+		Warning("unable to write entry %v to the state store: %v", key, err)
+	}
+}
+
+func (store *BoltStore) Snooze(key EntryKey, until time.Time) {
+	store.updateEntry(key, func(entry *entryState) { entry.Snoozed = until })
+}
+
+func (store *BoltStore) Snoozed(key EntryKey) (time.Time, bool) {
+	if entry, ok := store.entry(key); ok && !entry.Snoozed.IsZero() {
+		return entry.Snoozed, true
+	}
+	return time.Time{}, false
+}
+
+func (store *BoltStore) MarkReviewed(key EntryKey, head string) {
+	store.updateEntry(key, func(entry *entryState) { entry.Reviewed = head })
+}
+
+func (store *BoltStore) Reviewed(key EntryKey) (string, bool) {
+	if entry, ok := store.entry(key); ok && entry.Reviewed != "" {
+		return entry.Reviewed, true
+	}
+	return "", false
+}
+
+// Flush removes the records older than the retention period along with the
+// entries that no longer carry any state as the changes are written as they're
+// made.
+func (store *BoltStore) Flush() error {
+	before := boltKey(time.Now().Add(-store.retention), 0)
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltAssignments, boltEscalations, boltNotifications} {
+			cursor := tx.Bucket(bucket).Cursor()
+			for key, _ := cursor.First(); key != nil && bytes.Compare(key, before) < 0; key, _ = cursor.Next() {
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+
+		now := time.Now()
+		cursor := tx.Bucket(boltEntries).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			var entry entryState
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+
+			if entry.Reviewed == "" && entry.Snoozed.Before(now) {
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJSONStore(t *testing.T) {
+	Debug("[ json store ]==========================================")
+
+	dir, err := ioutil.TempDir("", "gups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	retention := 30 * 24 * time.Hour
+	now := time.Now()
+
+	snoozed := EntryKey{User: "u1", Path: "org/repo", Number: 1}
+	reviewed := EntryKey{User: "u2", Path: "org/repo", Number: 1}
+	expired := EntryKey{User: "u3", Path: "org/repo", Number: 1}
+
+	store := OpenJSONStore(path, retention, false)
+	store.AddAssignment(AssignmentRecord{User: "u1", Path: "org/repo", Number: 1, Time: now})
+	store.AddAssignment(AssignmentRecord{User: "u2", Path: "org/repo", Number: 2, Time: now.AddDate(0, 0, -60)})
+	store.AddEscalation(EscalationRecord{User: "u2", Path: "org/repo", Number: 1, Picked: []string{"u1"}, Time: now})
+	store.AddNotification(NotificationRecord{User: "u1", Path: "org/repo", Number: 1, Category: "assigned", Time: now})
+	store.Snooze(snoozed, now.Add(time.Hour))
+	store.MarkReviewed(reviewed, "abc")
+	store.Snooze(expired, now.Add(-time.Hour))
+
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = OpenJSONStore(path, retention, false)
+
+	if val := len(store.Assignments(time.Time{})); val != 1 {
+		t.Errorf("assignments: val=%v exp=1", val)
+	}
+	if val := len(store.Assignments(now.Add(time.Minute))); val != 0 {
+		t.Errorf("assignments-since: val=%v exp=0", val)
+	}
+	if val := len(store.Escalations(time.Time{})); val != 1 {
+		t.Errorf("escalations: val=%v exp=1", val)
+	}
+	if val := len(store.Notifications(time.Time{})); val != 1 {
+		t.Errorf("notifications: val=%v exp=1", val)
+	}
+
+	if until, ok := store.Snoozed(snoozed); !ok || !until.Equal(now.Add(time.Hour)) {
+		t.Errorf("snoozed: val=%v exp=%v", until, now.Add(time.Hour))
+	}
+	if head, ok := store.Reviewed(reviewed); !ok || head != "abc" {
+		t.Errorf("reviewed: val=%v exp=abc", head)
+	}
+	if _, ok := store.Snoozed(expired); ok {
+		t.Errorf("expired snooze wasn't pruned")
+	}
+
+	readOnly := OpenJSONStore(path, retention, true)
+	readOnly.AddAssignment(AssignmentRecord{User: "u3", Time: now})
+	if err := readOnly.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if val := len(OpenJSONStore(path, retention, false).Assignments(time.Time{})); val != 1 {
+		t.Errorf("read-only assignments: val=%v exp=1", val)
+	}
+}
+
+func TestJSONStoreDirty(t *testing.T) {
+	Debug("[ json store dirty ]====================================")
+
+	dir, err := ioutil.TempDir("", "gups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	store := OpenJSONStore(path, 30*24*time.Hour, false)
+
+	exists := func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	if err := store.Flush(); err != nil || exists() {
+		t.Errorf("unchanged store was written: %v", err)
+	}
+
+	store.Snooze(EntryKey{User: "u1", Path: "org/repo", Number: 1}, time.Now().Add(time.Hour))
+	if err := store.Flush(); err != nil || !exists() {
+		t.Errorf("changed store wasn't written: %v", err)
+	}
+
+	os.Remove(path)
+	if err := store.Flush(); err != nil || exists() {
+		t.Errorf("flushed store was rewritten: %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	Debug("[ memory store ]========================================")
+
+	now := time.Now()
+	store := NewMemoryStore(30 * 24 * time.Hour)
+	store.AddAssignment(AssignmentRecord{User: "u1", Time: now})
+	store.AddAssignment(AssignmentRecord{User: "u2", Time: now.AddDate(0, 0, -60)})
+	store.AddEscalation(EscalationRecord{User: "u2", Time: now.AddDate(0, 0, -60)})
+	store.AddNotification(NotificationRecord{User: "u1", Time: now.AddDate(0, 0, -60)})
+	store.Snooze(EntryKey{User: "u1"}, now.Add(-time.Hour))
+
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if val := len(store.Assignments(time.Time{})); val != 1 {
+		t.Errorf("assignments: val=%v exp=1", val)
+	}
+	if val := len(store.Escalations(time.Time{})); val != 0 {
+		t.Errorf("escalations: val=%v exp=0", val)
+	}
+	if val := len(store.Notifications(time.Time{})); val != 0 {
+		t.Errorf("notifications: val=%v exp=0", val)
+	}
+	if _, ok := store.Snoozed(EntryKey{User: "u1"}); ok {
+		t.Errorf("expired snooze wasn't pruned")
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	Debug("[ bolt store ]==========================================")
+
+	dir, err := ioutil.TempDir("", "gups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.db")
+	retention := 30 * 24 * time.Hour
+	now := time.Now()
+
+	snoozed := EntryKey{User: "u1", Path: "org/repo", Number: 1}
+	reviewed := EntryKey{User: "u2", Path: "org/repo", Number: 1}
+	expired := EntryKey{User: "u3", Path: "org/repo", Number: 1}
+
+	store := OpenBoltStore(path, retention, false)
+	store.AddAssignment(AssignmentRecord{User: "u1", Path: "org/repo", Number: 1, Time: now})
+	store.AddAssignment(AssignmentRecord{User: "u1", Path: "org/repo", Number: 3, Time: now})
+	store.AddAssignment(AssignmentRecord{User: "u2", Path: "org/repo", Number: 2, Time: now.AddDate(0, 0, -60)})
+	store.AddEscalation(EscalationRecord{User: "u2", Path: "org/repo", Number: 1, Picked: []string{"u1"}, Time: now})
+	store.AddNotification(NotificationRecord{User: "u1", Path: "org/repo", Number: 1, Category: "assigned", Time: now})
+	store.Snooze(snoozed, now.Add(time.Hour))
+	store.MarkReviewed(reviewed, "abc")
+	store.Snooze(expired, now.Add(-time.Hour))
+
+	if val := len(store.Assignments(now.AddDate(0, 0, -90))); val != 3 {
+		t.Errorf("assignments-unflushed: val=%v exp=3", val)
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	store.(*BoltStore).db.Close()
+
+	readOnly := OpenBoltStore(path, retention, true)
+	readOnly.AddAssignment(AssignmentRecord{User: "u3", Time: now})
+	if err := readOnly.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = OpenBoltStore(path, retention, false)
+	defer store.(*BoltStore).db.Close()
+
+	for _, store := range []Store{store, readOnly} {
+		exp := 2
+		if store == readOnly {
+			exp = 3
+		}
+
+		if val := len(store.Assignments(time.Time{})); val != exp {
+			t.Errorf("assignments: val=%v exp=%v", val, exp)
+		}
+		if val := len(store.Assignments(now.Add(time.Minute))); val != 0 {
+			t.Errorf("assignments-since: val=%v exp=0", val)
+		}
+		if val := len(store.Escalations(time.Time{})); val != 1 {
+			t.Errorf("escalations: val=%v exp=1", val)
+		}
+		if val := len(store.Notifications(time.Time{})); val != 1 {
+			t.Errorf("notifications: val=%v exp=1", val)
+		}
+
+		if until, ok := store.Snoozed(snoozed); !ok || !until.Equal(now.Add(time.Hour)) {
+			t.Errorf("snoozed: val=%v exp=%v", until, now.Add(time.Hour))
+		}
+		if head, ok := store.Reviewed(reviewed); !ok || head != "abc" {
+			t.Errorf("reviewed: val=%v exp=abc", head)
+		}
+		if _, ok := store.Snoozed(expired); ok {
+			t.Errorf("expired snooze wasn't pruned")
+		}
+	}
+
+	store.MarkReviewed(reviewed, "")
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Reviewed(reviewed); ok {
+		t.Errorf("cleared review wasn't pruned")
+	}
+}
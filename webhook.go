@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const maxPayloadSize = 10 * 1024 * 1024
+
+type githubEvent struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Number int `json:"number"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+var githubEventActions = map[string]Set{
+	"pull_request":        NewSet("opened", "reopened", "ready_for_review", "labeled", "synchronize"),
+	"pull_request_review": NewSet("submitted", "dismissed"),
+}
+
+// ValidGithubSignature checks the `X-Hub-Signature-256` header of a Github
+// webhook against the HMAC-SHA256 of the payload.
+func ValidGithubSignature(secret, signature string, payload []byte) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// GithubHandler returns the handler for Github webhooks signed with the given
+// secret. Matching PR events are processed in the background to keep within
+// Github's timeouts.
+func GithubHandler(engine *Engine, secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+		if err != nil {
+			http.Error(w, "unable to read payload", http.StatusBadRequest)
+			return
+		}
+
+		if !ValidGithubSignature(secret, r.Header.Get("X-Hub-Signature-256"), payload) {
+			Warning("invalid github webhook signature")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		kind := r.Header.Get("X-GitHub-Event")
+		actions, ok := githubEventActions[kind]
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		var event githubEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			http.Error(w, "malformed payload", http.StatusBadRequest)
+			return
+		}
+
+		if !actions.Test(event.Action) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		repo, ok := engine.Repo(event.Repository.FullName)
+		if !ok {
+			Info("ignoring %v event for unknown repo '%v'", kind, event.Repository.FullName)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		Info("received %v.%v for %v#%v", kind, event.Action, repo.Path, event.PullRequest.Number)
+		engine.Async(func() { engine.RunPullRequest(repo, event.PullRequest.Number, NewSet()) })
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
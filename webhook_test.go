@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGithubSignature(t *testing.T) {
+	Debug("[ github signature ]====================================")
+
+	secret := "It's a Secret to Everybody"
+	payload := []byte("Hello, World!")
+	signature := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+
+	if !ValidGithubSignature(secret, signature, payload) {
+		t.Errorf("expected valid signature")
+	}
+
+	for _, invalid := range []string{
+		"",
+		"757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+		"sha1=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+		"sha256=857107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+		"sha256=not-hex",
+	} {
+		if ValidGithubSignature(secret, invalid, payload) {
+			t.Errorf("expected invalid signature '%v'", invalid)
+		}
+	}
+
+	if ValidGithubSignature("wrong", signature, payload) {
+		t.Errorf("expected invalid signature with wrong secret")
+	}
+}